@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"strings"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/config"
+	"ip_detector/internal/domain/port"
+)
+
+// newGeoIPService builds the provider(s) named in GEOIP_PROVIDER. A comma
+// separated list becomes a failover chain tried in the given order. The
+// first result is wrapped in a cache; the second is the same provider
+// without it, for re-enrichment. File-based databases are watched for
+// changes until ctx is done.
+func newGeoIPService(ctx context.Context, cfg *config.Config) (cached, provider port.GeoIPService) {
+	b := &geoIPBuilder{cfg: cfg}
+
+	var svc port.GeoIPService
+	names := strings.Split(cfg.GeoIPProvider, ",")
+	if len(names) == 1 {
+		svc = b.provider(strings.TrimSpace(names[0]))
+	} else {
+		providers := make([]geoip.ChainProvider, 0, len(names))
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			providers = append(providers, geoip.ChainProvider{Name: name, Service: b.provider(name)})
+		}
+		svc = geoip.NewChain(providers, geoip.ChainConfig{
+			FailureThreshold: cfg.GeoIPBreakerThreshold,
+			Cooldown:         cfg.GeoIPBreakerCooldown,
+		})
+	}
+
+	provider = svc
+	if cfg.GeoIPCacheSize > 0 {
+		cache := geoip.NewCachedService(svc, geoip.CacheConfig{
+			Size:        cfg.GeoIPCacheSize,
+			TTL:         cfg.GeoIPCacheTTL,
+			NegativeTTL: cfg.GeoIPCacheNegativeTTL,
+		})
+		for _, r := range b.reloadables {
+			r.OnReload(cache.Purge)
+		}
+		svc = cache
+	}
+
+	for _, r := range b.reloadables {
+		go r.Watch(ctx)
+	}
+	return svc, provider
+}
+
+type geoIPBuilder struct {
+	cfg         *config.Config
+	reloadables []*geoip.Reloadable
+}
+
+// defaultMMDBCanaries are checked when GEOIP_CANARY_IPS is empty. A cidr
+// file usually covers only custom ranges, so it gets no default canaries.
+const defaultMMDBCanaries = "8.8.8.8,1.1.1.1"
+
+func (b *geoIPBuilder) provider(name string) port.GeoIPService {
+	cfg := b.cfg
+	switch name {
+	case "ipapi":
+		log.Printf("GeoIP provider: ip-api (%s)", cfg.GeoIPAPIURL)
+		return geoip.NewIPAPIService(cfg.GeoIPAPIURL, geoip.IPAPIConfig{
+			BatchURL:       cfg.GeoIPBatchURL,
+			ConnectTimeout: cfg.GeoIPConnectTimeout,
+			ReadTimeout:    cfg.GeoIPReadTimeout,
+			LookupTimeout:  cfg.GeoIPLookupTimeout,
+			MaxQuotaWait:   cfg.GeoIPMaxQuotaWait,
+		})
+	case "mmdb":
+		log.Printf("GeoIP provider: mmdb (%s)", cfg.GeoIPMMDBPath)
+		canaries := cmp.Or(cfg.GeoIPCanaryIPs, defaultMMDBCanaries)
+		return b.reloadable([]string{cfg.GeoIPMMDBPath}, canaries, func() (geoip.Database, error) {
+			return geoip.NewMMDBService(cfg.GeoIPMMDBPath)
+		})
+	case "cidr":
+		paths := strings.Split(cfg.GeoIPCIDRPaths, ",")
+		log.Printf("GeoIP provider: cidr (%s)", cfg.GeoIPCIDRPaths)
+		return b.reloadable(paths, cfg.GeoIPCanaryIPs, func() (geoip.Database, error) {
+			return geoip.NewCIDRService(paths)
+		})
+	default:
+		log.Fatalf("unknown GeoIP provider %q (want ipapi, mmdb or cidr)", name)
+		return nil
+	}
+}
+
+func (b *geoIPBuilder) reloadable(paths []string, canaries string, load func() (geoip.Database, error)) port.GeoIPService {
+	r, err := geoip.NewReloadable(load, geoip.ReloadConfig{
+		Paths:        paths,
+		PollInterval: b.cfg.GeoIPReloadInterval,
+		Canaries:     splitList(canaries),
+	})
+	if err != nil {
+		log.Fatalf("failed to load GeoIP database %v: %v", paths, err)
+	}
+	b.reloadables = append(b.reloadables, r)
+	return r
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
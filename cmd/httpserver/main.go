@@ -9,10 +9,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"ip_detector/internal/logger"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -24,9 +26,11 @@ import (
 
 	_ "ip_detector/docs"
 	"ip_detector/internal/adapter/db/postgres"
-	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/adapter/http/handler"
+	"ip_detector/internal/adapter/http/middleware"
 	"ip_detector/internal/adapter/http/router"
 	"ip_detector/internal/app/service"
+	"ip_detector/internal/auth"
 	"ip_detector/internal/config"
 )
 
@@ -34,28 +38,103 @@ func main() {
 	logger.Init()
 	cfg := config.LoadConfig()
 
-	applyMigrations(cfg.DSN())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reenrich":
+			runReenrich(cfg, os.Args[2:])
+			return
+		case "set-role":
+			runSetRole(cfg, os.Args[2:])
+			return
+		}
+	}
 
-	db, err := sql.Open("postgres", cfg.DSN())
+	db := openDB(cfg)
+
+	userRepo := postgres.NewPostgresUserRepo(db)
+	geoIP, geoIPProvider := newGeoIPService(context.Background(), cfg)
+
+	keys, err := auth.LoadKeySet(cfg.JWTSecret, cfg.JWTSigningKeyPath, splitList(cfg.JWTVerifyKeyPaths))
 	if err != nil {
-		log.Fatalf("failed to connect to db: %v", err)
+		log.Fatalf("failed to load JWT keys: %v", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		log.Fatalf("failed to ping db: %v", err)
+	reservedIPPolicy, err := service.ParseReservedIPPolicy(cfg.ReservedIPPolicy, cfg.ReservedIPCountryCode)
+	if err != nil {
+		log.Fatalf("invalid RESERVED_IP_POLICY/RESERVED_IP_COUNTRY: %v", err)
 	}
 
-	userRepo := postgres.NewPostgresUserRepo(db)
-	geoIP := geoip.NewIPAPIService("http://ip-api.com/json")
+	enrichmentMode, err := service.ParseEnrichmentMode(cfg.EnrichmentMode)
+	if err != nil {
+		log.Fatalf("invalid ENRICHMENT_MODE: %v", err)
+	}
 
 	serviceConfig := &service.Config{
-		JWTSecret:     cfg.JWTSecret,
-		JWTExpiration: cfg.JWTExpiration,
+		Keys:            keys,
+		JWTExpiration:   cfg.JWTExpiration,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+
+		ReservedIPPolicy:      reservedIPPolicy,
+		ReservedIPCountryCode: cfg.ReservedIPCountryCode,
+
+		EnrichmentMode: enrichmentMode,
+	}
+
+	refreshTokens := postgres.NewPostgresRefreshTokenStore(db)
+	revocationService := service.NewRevocationService(postgres.NewPostgresTokenRevocationStore(db), refreshTokens, serviceConfig)
+	if err := revocationService.Sync(context.Background()); err != nil {
+		log.Fatalf("failed to load token revocations: %v", err)
+	}
+	go revocationService.Run(context.Background(), cfg.TokenRevocationSyncInterval)
+
+	userService := service.NewUserService(userRepo, geoIP, revocationService, serviceConfig)
+
+	if serviceConfig.EnrichmentMode == service.EnrichmentAsync {
+		worker := service.NewEnrichmentWorker(postgres.NewPostgresEnrichmentQueue(db), geoIP, service.EnrichmentWorkerConfig{
+			Workers:      cfg.EnrichmentWorkers,
+			PollInterval: cfg.EnrichmentPollInterval,
+			Lease:        cfg.EnrichmentLease,
+			MaxAttempts:  cfg.EnrichmentMaxAttempts,
+			Backoff:      cfg.EnrichmentBackoff,
+			MaxBackoff:   cfg.EnrichmentMaxBackoff,
+		})
+		go worker.Run(context.Background())
 	}
 
-	userService := service.NewUserService(userRepo, geoIP, serviceConfig)
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	clientIPHeader, err := middleware.ParseClientIPHeader(cfg.ClientIPHeader)
+	if err != nil {
+		log.Fatalf("invalid CLIENT_IP_HEADER: %v", err)
+	}
+	clientIPMode, err := handler.ParseClientIPMode(cfg.ClientIPMode)
+	if err != nil {
+		log.Fatalf("invalid CLIENT_IP_MODE: %v", err)
+	}
 
-	r := router.SetupRouter(userService, cfg.JWTSecret).(*mux.Router)
+	lookupService := service.NewLookupService(geoIP)
+	reenrichService := service.NewReenrichService(userRepo, geoIPProvider)
+	tokenService := service.NewTokenService(userRepo, refreshTokens, revocationService, serviceConfig)
+
+	r := router.SetupRouter(&router.Services{
+		Users:       userService,
+		Tokens:      tokenService,
+		Revocations: revocationService,
+		Lookup:      lookupService,
+		Reenrich:    reenrichService,
+	}, &router.Config{
+		Keys:           keys,
+		TrustedProxies: trustedProxies,
+		ClientIPHeader: clientIPHeader,
+		ClientIPMode:   clientIPMode,
+		BulkLookup: handler.BulkConfig{
+			MaxIPs:      cfg.BulkLookupMaxIPs,
+			Concurrency: cfg.BulkLookupConcurrency,
+		},
+		ReenrichInterval: cfg.ReenrichInterval,
+	}).(*mux.Router)
 
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
@@ -65,6 +144,21 @@ func main() {
 	}
 }
 
+// openDB applies pending migrations and connects to Postgres.
+func openDB(cfg *config.Config) *sql.DB {
+	applyMigrations(cfg.DSN())
+
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		log.Fatalf("failed to connect to db: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("failed to ping db: %v", err)
+	}
+	return db
+}
+
 func applyMigrations(dsn string) {
 	m, err := migrate.New(
 		"file://./migrations",
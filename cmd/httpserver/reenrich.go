@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ip_detector/internal/adapter/db/postgres"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/config"
+	"ip_detector/internal/domain/model"
+)
+
+// runReenrich implements the "reenrich" maintenance command:
+//
+//	ip_detector reenrich [-empty-country] [-older-than-days N] [-ids id,...] [-apply]
+func runReenrich(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("reenrich", flag.ExitOnError)
+	emptyCountry := fs.Bool("empty-country", false, "select users without a country or with unfinished enrichment")
+	olderThan := fs.Int("older-than-days", 0, "select users enriched more than N days ago (or never)")
+	ids := fs.String("ids", "", "comma separated user IDs to select")
+	limit := fs.Int("limit", 0, "maximum number of users to process (0 = no limit)")
+	apply := fs.Bool("apply", false, "write the new locations; without it only the diff is printed")
+	interval := fs.Duration("interval", cfg.ReenrichInterval, "minimum time between two GeoIP lookups")
+	_ = fs.Parse(args)
+
+	sel := model.ReenrichSelector{EmptyCountry: *emptyCountry, IDs: splitList(*ids), Limit: *limit}
+	if *olderThan > 0 {
+		before := time.Now().AddDate(0, 0, -*olderThan)
+		sel.EnrichedBefore = &before
+	}
+	if !sel.EmptyCountry && sel.EnrichedBefore == nil && len(sel.IDs) == 0 {
+		fmt.Fprintln(os.Stderr, "reenrich: select users with -empty-country, -older-than-days or -ids")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	_, provider := newGeoIPService(ctx, cfg)
+	svc := service.NewReenrichService(postgres.NewPostgresUserRepo(db), provider)
+	report, err := svc.Run(ctx, service.ReenrichOptions{Selector: sel, Apply: *apply, Interval: *interval})
+	if report != nil {
+		printReenrichReport(report)
+	}
+	if err != nil {
+		log.Fatalf("reenrich failed: %v", err)
+	}
+}
+
+func printReenrichReport(report *model.ReenrichReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tEMAIL\tIP\tOLD\tNEW\tERROR")
+	for _, c := range report.Changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.UserID, c.Email, c.IP,
+			orDash(c.OldCountryCode), orDash(c.NewCountryCode), c.Error)
+	}
+	_ = tw.Flush()
+
+	mode := "applied"
+	if report.DryRun {
+		mode = "dry run, nothing written"
+	}
+	fmt.Printf("\n%d selected, %d changed, %d unchanged, %d failed, %d skipped (%s)\n",
+		report.Selected, report.Changed, report.Unchanged, report.Failed, report.Skipped, mode)
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
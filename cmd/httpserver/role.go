@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"ip_detector/internal/adapter/db/postgres"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/config"
+	"ip_detector/internal/domain/model"
+)
+
+// runSetRole implements the "set-role" maintenance command, e.g. to promote
+// an existing user to the first admin:
+//
+//	ip_detector set-role -email admin@example.com [-role admin]
+//
+// Changing the role logs the user out; running servers notice within
+// TOKEN_REVOCATION_SYNC_INTERVAL.
+func runSetRole(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user to change")
+	role := fs.String("role", string(model.RoleAdmin), "new role: admin or user")
+	_ = fs.Parse(args)
+
+	if *email == "" || !model.Role(*role).Valid() {
+		fmt.Fprintln(os.Stderr, "set-role: -email and a -role of admin or user are required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	svc := service.NewUserService(postgres.NewPostgresUserRepo(db), nil, nil, &service.Config{})
+	user, err := svc.SetRole(context.Background(), *email, model.Role(*role))
+	if err != nil {
+		log.Fatalf("set-role failed: %v", err)
+	}
+	fmt.Printf("%s (%s) is now %s\n", user.Email, user.ID, user.Role)
+}
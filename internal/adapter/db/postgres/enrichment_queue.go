@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ip_detector/internal/domain/model"
+)
+
+// PostgresEnrichmentQueue keeps enrichment jobs in the enrichment_jobs
+// table. PostgresUserRepo adds the jobs together with the pending users;
+// workers claim them with FOR UPDATE SKIP LOCKED, so several server
+// processes can share the queue.
+type PostgresEnrichmentQueue struct {
+	db *sql.DB
+}
+
+func NewPostgresEnrichmentQueue(db *sql.DB) *PostgresEnrichmentQueue {
+	return &PostgresEnrichmentQueue{db: db}
+}
+
+// Claim leases due jobs. A job whose lease expired, because its worker
+// died, becomes due again.
+func (q *PostgresEnrichmentQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.EnrichmentJob, error) {
+	query := `
+		UPDATE enrichment_jobs
+		SET attempts = attempts + 1,
+			locked_until = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM enrichment_jobs
+			WHERE run_at <= now() AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, ip, attempts
+	`
+	rows, err := q.db.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim enrichment jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*model.EnrichmentJob
+	for rows.Next() {
+		var job model.EnrichmentJob
+		if err := rows.Scan(&job.ID, &job.UserID, &job.IP, &job.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan enrichment job: %w", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+// Complete only updates the user while it still has the job's IP. When the
+// IP was changed meanwhile, the job for the new IP stores the location.
+func (q *PostgresEnrichmentQueue) Complete(ctx context.Context, job *model.EnrichmentJob, loc *model.GeoLocation) error {
+	return inTx(ctx, q.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, updateGeoLocationQuery, geoLocationArgs(job.UserID, job.IP, loc)...)
+		if err != nil {
+			return fmt.Errorf("failed to update enriched user: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM enrichment_jobs WHERE id = $1`, job.ID); err != nil {
+			return fmt.Errorf("failed to delete enrichment job: %w", err)
+		}
+		return nil
+	})
+}
+
+func (q *PostgresEnrichmentQueue) Retry(ctx context.Context, job *model.EnrichmentJob, runAt time.Time, reason string) error {
+	query := `UPDATE enrichment_jobs SET run_at = $2, locked_until = NULL, last_error = $3 WHERE id = $1`
+	if _, err := q.db.ExecContext(ctx, query, job.ID, runAt, reason); err != nil {
+		return fmt.Errorf("failed to reschedule enrichment job: %w", err)
+	}
+	return nil
+}
+
+// Fail deletes the job; the worker logs the reason. Like Complete, it
+// leaves a user whose IP changed meanwhile alone.
+func (q *PostgresEnrichmentQueue) Fail(ctx context.Context, job *model.EnrichmentJob, reason string) error {
+	return inTx(ctx, q.db, func(tx *sql.Tx) error {
+		query := `UPDATE users SET enrichment_status = 'failed' WHERE id = $1 AND ip = $2::inet`
+		if _, err := tx.ExecContext(ctx, query, job.UserID, job.IP); err != nil {
+			return fmt.Errorf("failed to mark user as failed: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM enrichment_jobs WHERE id = $1`, job.ID); err != nil {
+			return fmt.Errorf("failed to delete enrichment job: %w", err)
+		}
+		return nil
+	})
+}
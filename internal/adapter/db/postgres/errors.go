@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"ip_detector/internal/domain/model"
+)
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	pqUniqueViolation           = "23505"
+	pqInvalidTextRepresentation = "22P02"
+)
+
+// translateError maps storage errors to the domain errors in model. The
+// original error is kept in the message for logging.
+func translateError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.ErrNotFound
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch {
+	case pqErr.Code == pqUniqueViolation && pqErr.Constraint == "users_email_active_idx":
+		return fmt.Errorf("%w: %v", model.ErrDuplicateEmail, err)
+	case pqErr.Code == pqInvalidTextRepresentation:
+		// The only text parsed by Postgres in our queries is UUIDs.
+		return fmt.Errorf("%w: %v", model.ErrInvalidID, err)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"ip_detector/internal/domain/model"
+)
+
+// PostgresRefreshTokenStore keeps refresh token hashes in the
+// refresh_tokens table. Rotated and revoked rows are kept until they
+// expire, so that a replayed token is recognized as reuse.
+type PostgresRefreshTokenStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRefreshTokenStore(db *sql.DB) *PostgresRefreshTokenStore {
+	return &PostgresRefreshTokenStore{db: db}
+}
+
+const insertRefreshTokenQuery = `
+	INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+	VALUES ($1, COALESCE(NULLIF($2, '')::uuid, gen_random_uuid()), $3, $4)
+	RETURNING id, family_id
+`
+
+func (s *PostgresRefreshTokenStore) Create(ctx context.Context, token *model.RefreshToken) error {
+	err := s.db.QueryRowContext(ctx, insertRefreshTokenQuery,
+		token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt,
+	).Scan(&token.ID, &token.FamilyID)
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", translateError(err))
+	}
+	return nil
+}
+
+func (s *PostgresRefreshTokenStore) GetByHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+	var t model.RefreshToken
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(
+		&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", translateError(err))
+	}
+	return &t, nil
+}
+
+// Rotate only marks old as used if it still is unused and not revoked, so
+// of two concurrent refreshes with the same token only one succeeds.
+func (s *PostgresRefreshTokenStore) Rotate(ctx context.Context, old, next *model.RefreshToken) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `UPDATE refresh_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, old.ID)
+		if err != nil {
+			return fmt.Errorf("failed to mark refresh token as used: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return model.ErrRefreshTokenReused
+		}
+
+		err = tx.QueryRowContext(ctx, insertRefreshTokenQuery,
+			next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt,
+		).Scan(&next.ID, &next.FamilyID)
+		if err != nil {
+			return fmt.Errorf("failed to insert refresh token: %w", translateError(err))
+		}
+		return nil
+	})
+}
+
+func (s *PostgresRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := s.db.ExecContext(ctx, query, familyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresRefreshTokenStore) RevokeUser(ctx context.Context, userID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", translateError(err))
+	}
+	return nil
+}
+
+func (s *PostgresRefreshTokenStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+	return res.RowsAffected()
+}
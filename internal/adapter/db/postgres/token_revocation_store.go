@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ip_detector/internal/domain/model"
+)
+
+// PostgresTokenRevocationStore keeps revoked token IDs in the
+// revoked_tokens table and the per-user watermarks in
+// users.tokens_valid_after.
+type PostgresTokenRevocationStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTokenRevocationStore(db *sql.DB) *PostgresTokenRevocationStore {
+	return &PostgresTokenRevocationStore{db: db}
+}
+
+func (s *PostgresTokenRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresTokenRevocationStore) ListRevoked(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > now()`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
+
+func (s *PostgresTokenRevocationStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revocations: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresTokenRevocationStore) SetTokensValidAfter(ctx context.Context, userID string, t time.Time) error {
+	query := `UPDATE users SET tokens_valid_after = $2 WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, userID, t)
+	if err != nil {
+		return fmt.Errorf("failed to set token watermark: %w", translateError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+// ListTokensValidAfter includes deleted users.
+func (s *PostgresTokenRevocationStore) ListTokensValidAfter(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	query := `SELECT id, tokens_valid_after FROM users WHERE tokens_valid_after > $1`
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token watermarks: %w", err)
+	}
+	defer rows.Close()
+
+	validAfter := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, fmt.Errorf("failed to scan token watermark: %w", err)
+		}
+		validAfter[id] = t
+	}
+	return validAfter, rows.Err()
+}
@@ -0,0 +1,172 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ip_detector/internal/domain/model"
+)
+
+// userSortColumns maps sort fields to their column and the cast needed to
+// compare a cursor value with it.
+var userSortColumns = map[model.UserSort]struct{ column, cast string }{
+	model.SortByCreatedAt: {"created_at", "::timestamptz"},
+	model.SortByName:      {"name", ""},
+	model.SortByEmail:     {"email", ""},
+	model.SortByCountry:   {"country_code", ""},
+}
+
+// userCursor is the position after the last row of a page: its sort value
+// and ID, which breaks ties.
+type userCursor struct {
+	Sort  model.UserSort `json:"s"`
+	Desc  bool           `json:"d,omitempty"`
+	Value string         `json:"v"`
+	ID    string         `json:"id"`
+}
+
+func encodeCursor(c userCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a client-supplied cursor and checks that its value
+// can be compared with the sort column, so a crafted cursor is rejected
+// instead of failing the query.
+func decodeCursor(s string) (userCursor, error) {
+	var c userCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, model.ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, model.ErrInvalidCursor
+	}
+	if _, ok := userSortColumns[c.Sort]; !ok {
+		return c, model.ErrInvalidCursor
+	}
+	if c.Sort == model.SortByCreatedAt {
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return c, model.ErrInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+func sortValue(u *model.User, sort model.UserSort) string {
+	switch sort {
+	case model.SortByName:
+		return u.Name
+	case model.SortByEmail:
+		return u.Email
+	case model.SortByCountry:
+		return u.CountryCode
+	default:
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// likePattern escapes s for a substring ILIKE match.
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+func (r *PostgresUserRepo) Query(ctx context.Context, q model.UserQuery) (*model.UserPage, error) {
+	if q.Sort == "" {
+		q.Sort = model.SortByCreatedAt
+	}
+	query, args, err := buildUserQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		err = translateError(err)
+		if errors.Is(err, model.ErrInvalidID) {
+			// The only ID in the query is the one from the cursor.
+			return nil, model.ErrInvalidCursor
+		}
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.UserPage{Users: users}
+	if len(users) > q.Limit {
+		page.Users = users[:q.Limit]
+		last := page.Users[q.Limit-1]
+		page.NextCursor = encodeCursor(userCursor{Sort: q.Sort, Desc: q.Desc, Value: sortValue(last, q.Sort), ID: last.ID})
+	}
+	if page.Users == nil {
+		page.Users = []*model.User{}
+	}
+	return page, nil
+}
+
+// buildUserQuery returns the keyset query for q and its arguments. Rows are
+// ordered by the sort column and then the ID, so rows with equal sort values
+// are neither skipped nor repeated between pages.
+func buildUserQuery(q model.UserQuery) (string, []any, error) {
+	if q.Limit < 1 {
+		return "", nil, fmt.Errorf("invalid page size %d", q.Limit)
+	}
+	sortCol, ok := userSortColumns[q.Sort]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+
+	conds := []string{`deleted_at IS NULL`}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.Country != "" {
+		p := arg(q.Country)
+		conds = append(conds, fmt.Sprintf(`(upper(country_code) = upper(%s) OR lower(country) = lower(%s))`, p, p))
+	}
+	if q.Email != "" {
+		conds = append(conds, `email ILIKE `+arg(likePattern(q.Email)))
+	}
+	if q.Name != "" {
+		conds = append(conds, `name ILIKE `+arg(likePattern(q.Name)))
+	}
+	if q.CIDR != nil {
+		conds = append(conds, `ip <<= `+arg(q.CIDR.Masked().String())+`::inet`)
+	}
+	if q.RegisteredAfter != nil {
+		conds = append(conds, `created_at >= `+arg(*q.RegisteredAfter))
+	}
+	if q.RegisteredBefore != nil {
+		conds = append(conds, `created_at < `+arg(*q.RegisteredBefore))
+	}
+
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+			return "", nil, model.ErrInvalidCursor
+		}
+		conds = append(conds, fmt.Sprintf(`(%s, id) %s (%s%s, %s::uuid)`,
+			sortCol.column, cmp, arg(c.Value), sortCol.cast, arg(c.ID)))
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE ` + strings.Join(conds, ` AND `)
+	// One extra row tells whether there is a next page.
+	query += fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT %s`, sortCol.column, dir, dir, arg(q.Limit+1))
+	return query, args, nil
+}
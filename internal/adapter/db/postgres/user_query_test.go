@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ip_detector/internal/domain/model"
+)
+
+func TestBuildUserQuery(t *testing.T) {
+	created := time.Date(2025, 3, 1, 12, 0, 0, 500, time.UTC)
+	cidr := netip.MustParsePrefix("203.0.113.7/24")
+	id := "3f1c0c1e-8d2a-4b5e-9f6a-0b1c2d3e4f50"
+
+	cases := []struct {
+		name      string
+		q         model.UserQuery
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			name:      "first page",
+			q:         model.UserQuery{Limit: 2, Sort: model.SortByName},
+			wantWhere: `WHERE deleted_at IS NULL ORDER`,
+			wantOrder: `ORDER BY name ASC, id ASC LIMIT $1`,
+			wantArgs:  []any{3},
+		},
+		{
+			name: "filters",
+			q:    model.UserQuery{Limit: 10, Sort: model.SortByEmail, Country: "ua", Email: "50%_off", CIDR: &cidr},
+			wantWhere: `WHERE deleted_at IS NULL AND (upper(country_code) = upper($1) OR lower(country) = lower($1))` +
+				` AND email ILIKE $2 AND ip <<= $3::inet ORDER`,
+			wantOrder: `ORDER BY email ASC, id ASC LIMIT $4`,
+			wantArgs:  []any{"ua", `%50\%\_off%`, "203.0.113.0/24", 11},
+		},
+		{
+			name: "next page ascending",
+			q: model.UserQuery{Limit: 5, Sort: model.SortByCountry, Cursor: encodeCursor(userCursor{
+				Sort: model.SortByCountry, Value: "PL", ID: id,
+			})},
+			wantWhere: `AND (country_code, id) > ($1, $2::uuid) ORDER`,
+			wantOrder: `ORDER BY country_code ASC, id ASC LIMIT $3`,
+			wantArgs:  []any{"PL", id, 6},
+		},
+		{
+			name: "next page descending",
+			q: model.UserQuery{Limit: 5, Sort: model.SortByCreatedAt, Desc: true, Cursor: encodeCursor(userCursor{
+				Sort: model.SortByCreatedAt, Desc: true, Value: created.Format(time.RFC3339Nano), ID: id,
+			})},
+			wantWhere: `AND (created_at, id) < ($1::timestamptz, $2::uuid) ORDER`,
+			wantOrder: `ORDER BY created_at DESC, id DESC LIMIT $3`,
+			wantArgs:  []any{"2025-03-01T12:00:00.0000005Z", id, 6},
+		},
+	}
+	for _, tc := range cases {
+		query, args, err := buildUserQuery(tc.q)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !strings.Contains(query, tc.wantWhere) || !strings.HasSuffix(query, tc.wantOrder) {
+			t.Errorf("%s: unexpected query %s", tc.name, query)
+		}
+		if !reflect.DeepEqual(args, tc.wantArgs) {
+			t.Errorf("%s: want args %v, got %v", tc.name, tc.wantArgs, args)
+		}
+	}
+}
+
+func TestBuildUserQueryRejectsBadCursors(t *testing.T) {
+	id := "3f1c0c1e-8d2a-4b5e-9f6a-0b1c2d3e4f50"
+	cases := map[string]model.UserQuery{
+		"not base64": {Sort: model.SortByName, Cursor: "!!"},
+		"no ID":      {Sort: model.SortByName, Cursor: encodeCursor(userCursor{Sort: model.SortByName, Value: "Ann"})},
+		"other sort": {Sort: model.SortByName, Cursor: encodeCursor(userCursor{Sort: model.SortByEmail, Value: "a", ID: id})},
+		"other direction": {Sort: model.SortByName, Cursor: encodeCursor(userCursor{
+			Sort: model.SortByName, Desc: true, Value: "Ann", ID: id,
+		})},
+		"unknown sort": {Sort: model.SortByName, Cursor: encodeCursor(userCursor{Sort: "password", Value: "x", ID: id})},
+		"bad timestamp": {Sort: model.SortByCreatedAt, Cursor: encodeCursor(userCursor{
+			Sort: model.SortByCreatedAt, Value: "yesterday", ID: id,
+		})},
+	}
+	for name, q := range cases {
+		q.Limit = 10
+		if _, _, err := buildUserQuery(q); !errors.Is(err, model.ErrInvalidCursor) {
+			t.Errorf("%s: want ErrInvalidCursor, got %v", name, err)
+		}
+	}
+}
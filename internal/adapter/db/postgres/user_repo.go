@@ -5,8 +5,55 @@ import (
 	"database/sql"
 	"fmt"
 	"ip_detector/internal/domain/model"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+const userColumns = `id, name, email, host(ip), COALESCE(country, ''), country_code, region, city,
+	postal_code, latitude, longitude, timezone, asn, isp, geo_source,
+	enrichment_status, enriched_at, created_at, role,
+	last_login_at, COALESCE(host(last_login_ip), '')`
+
+// updateGeoLocationQuery stores the location resolved for an IP, unless the
+// user's IP changed meanwhile; the arguments follow geoLocationArgs.
+const updateGeoLocationQuery = `
+	UPDATE users
+	SET country = $3, country_code = $4, region = $5, city = $6, postal_code = $7,
+		latitude = $8, longitude = $9, timezone = $10, asn = $11, isp = $12, geo_source = $13,
+		enrichment_status = 'done', enriched_at = now()
+	WHERE id = $1 AND ip = $2::inet AND deleted_at IS NULL
+`
+
+func geoLocationArgs(id, ip string, loc *model.GeoLocation) []any {
+	return []any{id, ip, loc.Country, loc.CountryCode, loc.Region, loc.City, loc.PostalCode,
+		loc.Latitude, loc.Longitude, loc.Timezone, loc.ASN, loc.ISP, loc.Source}
+}
+
+// enqueueEnrichmentQuery queues the lookup of a pending user unless a job
+// for the same IP exists already; $1 is the user ID and $2 its IP.
+const enqueueEnrichmentQuery = `
+	INSERT INTO enrichment_jobs (user_id, ip)
+	SELECT $1::uuid, $2::text
+	WHERE NOT EXISTS (
+		SELECT 1 FROM enrichment_jobs WHERE user_id = $1::uuid AND ip = $2::text
+	)
+`
+
+// enqueuePending queues the lookup of a pending user within tx, so the user
+// is never stored as pending without a job.
+func enqueuePending(ctx context.Context, tx *sql.Tx, user *model.User) error {
+	if user.EnrichmentStatus != model.EnrichmentPending {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, enqueueEnrichmentQuery, user.ID, user.IP); err != nil {
+		return fmt.Errorf("failed to enqueue enrichment job: %w", err)
+	}
+	return nil
+}
+
 type PostgresUserRepo struct {
 	db *sql.DB
 }
@@ -15,72 +62,252 @@ func NewPostgresUserRepo(db *sql.DB) *PostgresUserRepo {
 	return &PostgresUserRepo{db: db}
 }
 
-func (r *PostgresUserRepo) Save(ctx context.Context, user *model.User) error {
-	query := `
-		INSERT INTO users (name, email, ip, country, password_hash)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`
-	err := r.db.QueryRowContext(ctx, query,
-		user.Name,
-		user.Email,
-		user.IP,
-		user.Country,
-		user.PasswordHash,
-	).Scan(&user.ID)
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+func scanUser(row rowScanner, extra ...any) (*model.User, error) {
+	var u model.User
+	dest := []any{
+		&u.ID, &u.Name, &u.Email, &u.IP, &u.Country, &u.CountryCode, &u.Region, &u.City,
+		&u.PostalCode, &u.Latitude, &u.Longitude, &u.Timezone, &u.ASN, &u.ISP, &u.GeoSource,
+		&u.EnrichmentStatus, &u.EnrichedAt, &u.CreatedAt, &u.Role,
+		&u.LastLoginAt, &u.LastLoginIP,
 	}
-	return nil
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
-func (r *PostgresUserRepo) GetAll(ctx context.Context) ([]*model.User, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT id, name, email, ip, country FROM users`)
+// normalizeIP returns the canonical text form of ip, with IPv4-mapped IPv6
+// addresses unmapped, so equal addresses are stored identically.
+func normalizeIP(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query users: %w", err)
+		return "", fmt.Errorf("invalid IP %q: %w", ip, err)
 	}
-	defer rows.Close()
+	return addr.Unmap().WithZone("").String(), nil
+}
 
+func scanUsers(rows *sql.Rows) ([]*model.User, error) {
 	var users []*model.User
-
 	for rows.Next() {
-		var u model.User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.IP, &u.Country); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
-		users = append(users, &u)
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
 	}
-
 	return users, nil
 }
 
-func (r *PostgresUserRepo) GetByID(ctx context.Context, id string) (*model.User, error) {
-	var user model.User
-	query := `SELECT id, name, email, ip, country FROM users WHERE id = $1`
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Email, &user.IP, &user.Country)
+func (r *PostgresUserRepo) Save(ctx context.Context, user *model.User) error {
+	ip, err := normalizeIP(user.IP)
+	if err != nil {
+		return err
+	}
+	user.IP = ip
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	query := `
+		INSERT INTO users (name, email, ip, country, country_code, region, city,
+			postal_code, latitude, longitude, timezone, asn, isp, geo_source,
+			enrichment_status, enriched_at, password_hash, role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id, created_at
+	`
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			user.Name,
+			user.Email,
+			user.IP,
+			user.Country,
+			user.CountryCode,
+			user.Region,
+			user.City,
+			user.PostalCode,
+			user.Latitude,
+			user.Longitude,
+			user.Timezone,
+			user.ASN,
+			user.ISP,
+			user.GeoSource,
+			user.EnrichmentStatus,
+			user.EnrichedAt,
+			user.PasswordHash,
+			user.Role,
+		).Scan(&user.ID, &user.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert user: %w", translateError(err))
+		}
+		return enqueuePending(ctx, tx, user)
+	})
+}
+
+func (r *PostgresUserRepo) Update(ctx context.Context, user *model.User) error {
+	ip, err := normalizeIP(user.IP)
+	if err != nil {
+		return err
+	}
+	user.IP = ip
+
+	query := `
+		UPDATE users
+		SET name = $2, email = $3, ip = $4, country = $5, country_code = $6, region = $7,
+			city = $8, postal_code = $9, latitude = $10, longitude = $11, timezone = $12,
+			asn = $13, isp = $14, geo_source = $15, enrichment_status = $16, enriched_at = $17
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query,
+			user.ID,
+			user.Name,
+			user.Email,
+			user.IP,
+			user.Country,
+			user.CountryCode,
+			user.Region,
+			user.City,
+			user.PostalCode,
+			user.Latitude,
+			user.Longitude,
+			user.Timezone,
+			user.ASN,
+			user.ISP,
+			user.GeoSource,
+			user.EnrichmentStatus,
+			user.EnrichedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", translateError(err))
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return model.ErrNotFound
+		}
+		return enqueuePending(ctx, tx, user)
+	})
+}
+
+func (r *PostgresUserRepo) SetRole(ctx context.Context, id string, role model.Role, at time.Time) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `UPDATE users SET role = $2, tokens_valid_after = $3 WHERE id = $1 AND deleted_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, id, role, at)
+		if err != nil {
+			return fmt.Errorf("failed to set user role: %w", translateError(err))
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return model.ErrNotFound
+		}
+		return revokeRefreshTokens(ctx, tx, id, at)
+	})
+}
+
+// revokeRefreshTokens revokes all of the user's refresh tokens within tx.
+func revokeRefreshTokens(ctx context.Context, tx *sql.Tx, id string, at time.Time) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, id, at); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresUserRepo) RecordLogin(ctx context.Context, id, ip string, at time.Time) error {
+	if ip != "" {
+		normalized, err := normalizeIP(ip)
+		if err != nil {
+			return err
+		}
+		ip = normalized
 	}
 
+	query := `UPDATE users SET last_login_at = $2, last_login_ip = NULLIF($3, '')::inet WHERE id = $1 AND deleted_at IS NULL`
+	res, err := r.db.ExecContext(ctx, query, id, at, ip)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by ID: %w", err)
+		return fmt.Errorf("failed to record login: %w", translateError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrNotFound
 	}
-	return &user, nil
+	return nil
+}
+
+func (r *PostgresUserRepo) SoftDelete(ctx context.Context, id string, at time.Time) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `UPDATE users SET deleted_at = $2, tokens_valid_after = $2 WHERE id = $1 AND deleted_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, id, at)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", translateError(err))
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return model.ErrNotFound
+		}
+		return revokeRefreshTokens(ctx, tx, id, at)
+	})
+}
+
+func (r *PostgresUserRepo) GetByID(ctx context.Context, id string) (*model.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by ID: %w", translateError(err))
+	}
+	return user, nil
 }
 
 func (r *PostgresUserRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
-	var user model.User
-	query := `SELECT id, name, email, ip, country, password_hash FROM users WHERE email = $1`
-	err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Name, &user.Email, &user.IP, &user.Country, &user.PasswordHash)
+	var passwordHash string
+	query := `SELECT ` + userColumns + `, password_hash FROM users WHERE email = $1 AND deleted_at IS NULL`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email), &passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by email: %w", translateError(err))
+	}
+	user.PasswordHash = passwordHash
+	return user, nil
+}
 
-	if err == sql.ErrNoRows {
+func (r *PostgresUserRepo) FindForReenrichment(ctx context.Context, sel model.ReenrichSelector) ([]*model.User, error) {
+	var conds []string
+	var args []any
+	if sel.EmptyCountry {
+		conds = append(conds, `COALESCE(country, '') = '' OR enrichment_status <> 'done'`)
+	}
+	if sel.EnrichedBefore != nil {
+		args = append(args, *sel.EnrichedBefore)
+		conds = append(conds, fmt.Sprintf(`enriched_at IS NULL OR enriched_at < $%d`, len(args)))
+	}
+	if len(sel.IDs) > 0 {
+		args = append(args, pq.Array(sel.IDs))
+		conds = append(conds, fmt.Sprintf(`id = ANY($%d::uuid[])`, len(args)))
+	}
+	if len(conds) == 0 {
 		return nil, nil
 	}
 
+	query := `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NULL AND ((` + strings.Join(conds, `) OR (`) + `)) ORDER BY enriched_at NULLS FIRST, id`
+	if sel.Limit > 0 {
+		args = append(args, sel.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user by email: %w", err)
+		return nil, fmt.Errorf("failed to query users for re-enrichment: %w", translateError(err))
 	}
-	return &user, nil
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+func (r *PostgresUserRepo) UpdateGeoLocation(ctx context.Context, id, ip string, loc *model.GeoLocation) error {
+	res, err := r.db.ExecContext(ctx, updateGeoLocationQuery, geoLocationArgs(id, ip, loc)...)
+	if err != nil {
+		return fmt.Errorf("failed to update user location: %w", translateError(err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrNotFound
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package geoip
+
+import (
+	"context"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+)
+
+// nativeBatch reports whether svc resolves batches in few round trips
+// rather than one lookup per IP.
+func nativeBatch(svc port.GeoIPService) bool {
+	batcher, ok := svc.(port.BatchGeoIPService)
+	return ok && batcher.NativeBatch()
+}
+
+// LookupBatch resolves ips through svc, using its native batch support when
+// it implements port.BatchGeoIPService and one lookup per IP otherwise.
+func LookupBatch(ctx context.Context, svc port.GeoIPService, ips []string) ([]model.GeoLookupResult, error) {
+	if batcher, ok := svc.(port.BatchGeoIPService); ok {
+		return batcher.LookupBatch(ctx, ips)
+	}
+
+	results := make([]model.GeoLookupResult, len(ips))
+	for i, ip := range ips {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		loc, err := svc.Lookup(ctx, ip)
+		results[i] = model.GeoLookupResult{IP: ip, Location: loc, Err: err}
+	}
+	return results, nil
+}
@@ -0,0 +1,146 @@
+package geoip_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+var (
+	_ port.BatchGeoIPService = (*geoip.IPAPIService)(nil)
+	_ port.BatchGeoIPService = (*geoip.CachedService)(nil)
+	_ port.BatchGeoIPService = (*geoip.Chain)(nil)
+)
+
+func TestIPAPILookupBatchChunks(t *testing.T) {
+	logger.Init()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/batch" {
+			http.NotFound(w, r)
+			return
+		}
+		// Fail the second chunk as a whole.
+		if calls.Add(1) == 2 {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+
+		var ips []string
+		if err := json.NewDecoder(r.Body).Decode(&ips); err != nil || len(ips) > 100 {
+			http.Error(w, "bad batch", http.StatusUnprocessableEntity)
+			return
+		}
+		out := make([]map[string]any, len(ips))
+		for i, ip := range ips {
+			if ip == "10.0.0.1" {
+				out[i] = map[string]any{"status": "fail", "message": "private range", "query": ip}
+				continue
+			}
+			out[i] = map[string]any{"status": "success", "countryCode": "US", "query": ip}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	ips := make([]string, 250)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("8.8.%d.%d", i/256, i%256)
+	}
+	ips[5] = "10.0.0.1"
+
+	svc := geoip.NewIPAPIService(srv.URL+"/json", geoip.IPAPIConfig{})
+	results, err := svc.LookupBatch(context.Background(), ips)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("want 3 chunks, got %d requests", calls.Load())
+	}
+	if len(results) != len(ips) {
+		t.Fatalf("want %d results, got %d", len(ips), len(results))
+	}
+
+	for i, r := range results {
+		if r.IP != ips[i] {
+			t.Fatalf("result %d out of order: %s", i, r.IP)
+		}
+		switch {
+		case i == 5:
+			if !errors.Is(r.Err, model.ErrLocationNotFound) {
+				t.Errorf("want not found for private IP, got %v", r.Err)
+			}
+		case i >= 100 && i < 200:
+			if r.Err == nil {
+				t.Errorf("result %d: want error from failed chunk", i)
+			}
+		default:
+			if r.Err != nil || r.Location.CountryCode != "US" {
+				t.Errorf("result %d: unexpected %+v", i, r)
+			}
+		}
+	}
+}
+
+func TestLookupBatchFallsBackToSingleLookups(t *testing.T) {
+	provider := &fakeProvider{country: "Ukraine"}
+
+	results, err := geoip.LookupBatch(context.Background(), provider, []string{"1.1.1.1", "2.2.2.2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.calls != 2 || results[1].IP != "2.2.2.2" || results[1].Location.Country != "Ukraine" {
+		t.Fatalf("unexpected results: %+v (%d calls)", results, provider.calls)
+	}
+}
+
+func TestCachedLookupBatch(t *testing.T) {
+	inner := &countingProvider{}
+	svc := geoip.NewCachedService(inner, geoip.CacheConfig{Size: 10, TTL: time.Hour})
+
+	if _, err := svc.Lookup(context.Background(), "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := svc.LookupBatch(context.Background(), []string{"1.1.1.1", "2.2.2.2", "2.2.2.2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := inner.calls.Load(); got != 2 {
+		t.Fatalf("want only the uncached IP to be fetched once, got %d calls", got)
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Location.IP != r.IP {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	}
+}
+
+func TestNativeBatch(t *testing.T) {
+	ipapi := geoip.NewIPAPIService("http://127.0.0.1/json", geoip.IPAPIConfig{})
+	cfg := geoip.CacheConfig{Size: 10, TTL: time.Hour}
+
+	if !geoip.NewCachedService(ipapi, cfg).NativeBatch() {
+		t.Error("want a cached ip-api to batch natively")
+	}
+	if geoip.NewCachedService(&countingProvider{}, cfg).NativeBatch() {
+		t.Error("want a cached single-lookup provider not to batch natively")
+	}
+	chain := geoip.NewChain([]geoip.ChainProvider{
+		{Name: "ipapi", Service: ipapi},
+		{Name: "fake", Service: &fakeProvider{}},
+	}, geoip.ChainConfig{})
+	if chain.NativeBatch() {
+		t.Error("want a chain with a single-lookup provider not to batch natively")
+	}
+}
@@ -0,0 +1,251 @@
+package geoip
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+)
+
+// CacheConfig controls CachedService. "Not found" answers are remembered for
+// NegativeTTL; zero disables negative caching. Other errors are never cached.
+type CacheConfig struct {
+	Size        int
+	TTL         time.Duration
+	NegativeTTL time.Duration
+}
+
+// CachedService is a port.GeoIPService decorator with a bounded LRU cache.
+// Concurrent lookups of the same IP share a single call to the wrapped
+// provider.
+type CachedService struct {
+	next port.GeoIPService
+	cfg  CacheConfig
+
+	mu       sync.Mutex
+	lru      *list.List
+	items    map[string]*list.Element
+	inflight map[string]*cacheCall
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	ip      string
+	loc     *model.GeoLocation
+	err     error
+	expires time.Time
+}
+
+type cacheCall struct {
+	done chan struct{}
+	loc  *model.GeoLocation
+	err  error
+}
+
+func NewCachedService(next port.GeoIPService, cfg CacheConfig) *CachedService {
+	return &CachedService{
+		next:     next,
+		cfg:      cfg,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+func (s *CachedService) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	s.mu.Lock()
+	if entry, ok := s.get(ip); ok {
+		s.mu.Unlock()
+		s.hits.Add(1)
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return copyLocation(entry.loc), nil
+	}
+	s.misses.Add(1)
+
+	c, ok := s.inflight[ip]
+	if !ok {
+		c = &cacheCall{done: make(chan struct{})}
+		s.inflight[ip] = c
+		// The shared call must not be aborted by whichever caller happened
+		// to start it; the wrapped provider enforces its own deadlines.
+		go s.fetch(context.WithoutCancel(ctx), ip, c)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return nil, c.err
+		}
+		return copyLocation(c.loc), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the current hit/miss counters and cache size.
+func (s *CachedService) Stats() model.GeoIPCacheStats {
+	s.mu.Lock()
+	entries := s.lru.Len()
+	s.mu.Unlock()
+
+	return model.GeoIPCacheStats{
+		Hits:    s.hits.Load(),
+		Misses:  s.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// Purge drops all cached entries, e.g. after the underlying database was
+// replaced.
+func (s *CachedService) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lru.Init()
+	clear(s.items)
+}
+
+// DatabaseInfo reports the databases of the wrapped provider, if any.
+func (s *CachedService) DatabaseInfo() []model.GeoIPDatabaseInfo {
+	if inspector, ok := s.next.(port.GeoIPDatabaseInspector); ok {
+		return inspector.DatabaseInfo()
+	}
+	return nil
+}
+
+func (s *CachedService) fetch(ctx context.Context, ip string, c *cacheCall) {
+	c.loc, c.err = s.next.Lookup(ctx, ip)
+
+	s.mu.Lock()
+	delete(s.inflight, ip)
+	switch {
+	case c.err == nil:
+		s.put(ip, c.loc, nil, s.cfg.TTL)
+	case s.cfg.NegativeTTL > 0 && errors.Is(c.err, model.ErrLocationNotFound):
+		s.put(ip, nil, c.err, s.cfg.NegativeTTL)
+	}
+	s.mu.Unlock()
+
+	close(c.done)
+}
+
+// get must be called with s.mu held.
+func (s *CachedService) get(ip string) (*cacheEntry, bool) {
+	el, ok := s.items[ip]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		s.lru.Remove(el)
+		delete(s.items, ip)
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return entry, true
+}
+
+// put must be called with s.mu held.
+func (s *CachedService) put(ip string, loc *model.GeoLocation, err error, ttl time.Duration) {
+	if s.cfg.Size <= 0 || ttl <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{ip: ip, loc: loc, err: err, expires: time.Now().Add(ttl)}
+	if el, ok := s.items[ip]; ok {
+		el.Value = entry
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.items[ip] = s.lru.PushFront(entry)
+
+	for s.lru.Len() > s.cfg.Size {
+		oldest := s.lru.Remove(s.lru.Back()).(*cacheEntry)
+		delete(s.items, oldest.ip)
+	}
+}
+
+// copyLocation returns a deep copy of loc, so callers cannot modify the
+// cached entry through the coordinate pointers either.
+func copyLocation(loc *model.GeoLocation) *model.GeoLocation {
+	cp := *loc
+	if loc.Latitude != nil {
+		lat := *loc.Latitude
+		cp.Latitude = &lat
+	}
+	if loc.Longitude != nil {
+		lon := *loc.Longitude
+		cp.Longitude = &lon
+	}
+	return &cp
+}
+
+// NativeBatch reports whether the wrapped provider batches natively.
+func (s *CachedService) NativeBatch() bool { return nativeBatch(s.next) }
+
+// LookupBatch serves cached IPs directly and resolves the rest with one
+// batch call to the wrapped provider.
+func (s *CachedService) LookupBatch(ctx context.Context, ips []string) ([]model.GeoLookupResult, error) {
+	results := make([]model.GeoLookupResult, len(ips))
+	var missing []string
+	missingAt := make(map[string][]int)
+
+	s.mu.Lock()
+	for i, ip := range ips {
+		if entry, ok := s.get(ip); ok {
+			s.hits.Add(1)
+			results[i] = cachedResult(ip, entry.loc, entry.err)
+			continue
+		}
+		s.misses.Add(1)
+		if _, seen := missingAt[ip]; !seen {
+			missing = append(missing, ip)
+		}
+		missingAt[ip] = append(missingAt[ip], i)
+	}
+	s.mu.Unlock()
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	fetched, err := LookupBatch(ctx, s.next, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	for _, r := range fetched {
+		switch {
+		case r.Err == nil:
+			s.put(r.IP, r.Location, nil, s.cfg.TTL)
+		case s.cfg.NegativeTTL > 0 && errors.Is(r.Err, model.ErrLocationNotFound):
+			s.put(r.IP, nil, r.Err, s.cfg.NegativeTTL)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, r := range fetched {
+		for _, i := range missingAt[r.IP] {
+			results[i] = cachedResult(r.IP, r.Location, r.Err)
+		}
+	}
+	return results, nil
+}
+
+func cachedResult(ip string, loc *model.GeoLocation, err error) model.GeoLookupResult {
+	if err != nil {
+		return model.GeoLookupResult{IP: ip, Err: err}
+	}
+	return model.GeoLookupResult{IP: ip, Location: copyLocation(loc)}
+}
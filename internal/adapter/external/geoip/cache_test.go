@@ -0,0 +1,130 @@
+package geoip_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/domain/model"
+)
+
+type countingProvider struct {
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (p *countingProvider) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	p.calls.Add(1)
+	if p.release != nil {
+		<-p.release
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	lat, lon := 50.45, 30.52
+	return &model.GeoLocation{IP: ip, CountryCode: "UA", Latitude: &lat, Longitude: &lon}, nil
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	inner := &countingProvider{}
+	svc := geoip.NewCachedService(inner, geoip.CacheConfig{Size: 10, TTL: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		loc, err := svc.Lookup(context.Background(), "8.8.8.8")
+		if err != nil || loc.CountryCode != "UA" {
+			t.Fatalf("unexpected result: %+v, %v", loc, err)
+		}
+	}
+
+	if got := inner.calls.Load(); got != 1 {
+		t.Fatalf("want 1 provider call, got %d", got)
+	}
+	if st := svc.Stats(); st.Hits != 2 || st.Misses != 1 || st.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	svc := geoip.NewCachedService(&countingProvider{}, geoip.CacheConfig{Size: 10, TTL: time.Hour})
+
+	loc, _ := svc.Lookup(context.Background(), "8.8.8.8")
+	loc.CountryCode = "XX"
+	*loc.Latitude = 0
+
+	loc, _ = svc.Lookup(context.Background(), "8.8.8.8")
+	if loc.CountryCode != "UA" || *loc.Latitude != 50.45 {
+		t.Fatalf("cached entry was modified through a returned location: %+v", loc)
+	}
+}
+
+func TestCacheTTLAndEviction(t *testing.T) {
+	inner := &countingProvider{}
+	svc := geoip.NewCachedService(inner, geoip.CacheConfig{Size: 2, TTL: 30 * time.Millisecond})
+	ctx := context.Background()
+
+	_, _ = svc.Lookup(ctx, "1.1.1.1")
+	_, _ = svc.Lookup(ctx, "2.2.2.2")
+	_, _ = svc.Lookup(ctx, "3.3.3.3") // evicts 1.1.1.1
+	_, _ = svc.Lookup(ctx, "1.1.1.1")
+	if got := inner.calls.Load(); got != 4 {
+		t.Fatalf("want LRU eviction to force a refetch, got %d calls", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	_, _ = svc.Lookup(ctx, "1.1.1.1")
+	if got := inner.calls.Load(); got != 5 {
+		t.Fatalf("want expired entry to be refetched, got %d calls", got)
+	}
+}
+
+func TestCacheNegativeTTL(t *testing.T) {
+	inner := &countingProvider{err: fmt.Errorf("%w: reserved range", model.ErrLocationNotFound)}
+	svc := geoip.NewCachedService(inner, geoip.CacheConfig{Size: 10, TTL: time.Hour, NegativeTTL: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Lookup(context.Background(), "10.0.0.1"); err == nil {
+			t.Fatal("want cached error")
+		}
+	}
+	if got := inner.calls.Load(); got != 1 {
+		t.Fatalf("want failed lookup to be cached, got %d calls", got)
+	}
+
+	inner.err = errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		_, _ = svc.Lookup(context.Background(), "8.8.8.8")
+	}
+	if got := inner.calls.Load(); got != 3 {
+		t.Fatalf("want provider errors not to be cached, got %d calls", got)
+	}
+}
+
+func TestCacheCoalescesConcurrentLookups(t *testing.T) {
+	inner := &countingProvider{release: make(chan struct{})}
+	svc := geoip.NewCachedService(inner, geoip.CacheConfig{Size: 10, TTL: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.Lookup(context.Background(), "8.8.8.8"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	if got := inner.calls.Load(); got != 1 {
+		t.Fatalf("want a single provider call, got %d", got)
+	}
+}
@@ -0,0 +1,200 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// ErrNoProviderAvailable is returned when every provider in a chain is
+// skipped because its circuit breaker is open.
+var ErrNoProviderAvailable = errors.New("no GeoIP provider available")
+
+// ChainProvider is one named entry of a Chain.
+type ChainProvider struct {
+	Name    string
+	Service port.GeoIPService
+}
+
+// ChainConfig configures the per-provider circuit breakers.
+type ChainConfig struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// ProviderStatus describes the breaker state of a chain entry.
+type ProviderStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// Chain tries its providers in order and returns the first answer. A
+// provider that keeps failing is skipped until its breaker cools down.
+// "Not found" answers move on to the next provider without counting as a
+// failure.
+type Chain struct {
+	providers []ChainProvider
+	breakers  []*circuitBreaker
+}
+
+func NewChain(providers []ChainProvider, cfg ChainConfig) *Chain {
+	breakers := make([]*circuitBreaker, len(providers))
+	for i := range providers {
+		breakers[i] = newCircuitBreaker(cfg.FailureThreshold, cfg.Cooldown)
+	}
+	return &Chain{providers: providers, breakers: breakers}
+}
+
+func (c *Chain) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	log := logger.Log.Sugar()
+
+	var errs []error
+	for i, p := range c.providers {
+		breaker := c.breakers[i]
+		if !breaker.Allow() {
+			log.Debugw("GeoIP provider skipped, circuit open", "provider", p.Name, "ip", ip)
+			continue
+		}
+
+		loc, err := p.Service.Lookup(ctx, ip)
+		if err == nil {
+			breaker.Success()
+			loc.Source = p.Name
+			log.Infow("GeoIP chain answered", "provider", p.Name, "ip", ip)
+			return loc, nil
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// The caller gave up; that says nothing about the provider.
+			breaker.Abort()
+			return nil, ctxErr
+		}
+
+		if errors.Is(err, model.ErrLocationNotFound) {
+			breaker.Success()
+		} else {
+			breaker.Failure()
+		}
+		log.Warnw("GeoIP provider failed", "provider", p.Name, "ip", ip, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrNoProviderAvailable
+	}
+	return nil, errors.Join(errs...)
+}
+
+// DatabaseInfo collects the databases of all file-based providers in the
+// chain.
+func (c *Chain) DatabaseInfo() []model.GeoIPDatabaseInfo {
+	var out []model.GeoIPDatabaseInfo
+	for _, p := range c.providers {
+		if inspector, ok := p.Service.(port.GeoIPDatabaseInspector); ok {
+			out = append(out, inspector.DatabaseInfo()...)
+		}
+	}
+	return out
+}
+
+// Status reports the breaker state of every provider, in chain order.
+func (c *Chain) Status() []ProviderStatus {
+	out := make([]ProviderStatus, len(c.providers))
+	for i, p := range c.providers {
+		state, failures := c.breakers[i].State()
+		out[i] = ProviderStatus{Name: p.Name, State: state.String(), Failures: failures}
+	}
+	return out
+}
+
+// NativeBatch is true when every provider batches natively, since a batch
+// may have to fall through to any of them.
+func (c *Chain) NativeBatch() bool {
+	for _, p := range c.providers {
+		if !nativeBatch(p.Service) {
+			return false
+		}
+	}
+	return true
+}
+
+// LookupBatch hands the whole batch to the first available provider and
+// passes whatever it could not resolve on to the next one.
+func (c *Chain) LookupBatch(ctx context.Context, ips []string) ([]model.GeoLookupResult, error) {
+	log := logger.Log.Sugar()
+
+	results := make([]model.GeoLookupResult, len(ips))
+	errs := make([][]error, len(ips))
+	pending := make([]int, len(ips))
+	for i := range ips {
+		pending[i] = i
+	}
+
+	for i, p := range c.providers {
+		if len(pending) == 0 {
+			break
+		}
+		breaker := c.breakers[i]
+		if !breaker.Allow() {
+			continue
+		}
+
+		batch := make([]string, len(pending))
+		for j, idx := range pending {
+			batch[j] = ips[idx]
+		}
+
+		res, err := LookupBatch(ctx, p.Service, batch)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				breaker.Abort()
+				return nil, ctxErr
+			}
+			breaker.Failure()
+			log.Warnw("GeoIP provider batch failed", "provider", p.Name, "count", len(batch), "error", err)
+			for _, idx := range pending {
+				errs[idx] = append(errs[idx], fmt.Errorf("%s: %w", p.Name, err))
+			}
+			continue
+		}
+
+		healthy := false
+		var next []int
+		for j, r := range res {
+			idx := pending[j]
+			if r.Err == nil {
+				r.Location.Source = p.Name
+				results[idx] = r
+				healthy = true
+				continue
+			}
+			if errors.Is(r.Err, model.ErrLocationNotFound) {
+				healthy = true
+			}
+			errs[idx] = append(errs[idx], fmt.Errorf("%s: %w", p.Name, r.Err))
+			next = append(next, idx)
+		}
+		if healthy {
+			breaker.Success()
+		} else {
+			breaker.Failure()
+		}
+		log.Infow("GeoIP chain batch answered", "provider", p.Name, "resolved", len(pending)-len(next))
+		pending = next
+	}
+
+	for _, idx := range pending {
+		err := errors.Join(errs[idx]...)
+		if err == nil {
+			err = ErrNoProviderAvailable
+		}
+		results[idx] = model.GeoLookupResult{IP: ips[idx], Err: err}
+	}
+	return results, nil
+}
@@ -0,0 +1,117 @@
+package geoip_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+type fakeProvider struct {
+	country string
+	err     error
+	calls   int
+}
+
+func (f *fakeProvider) Lookup(_ context.Context, ip string) (*model.GeoLocation, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &model.GeoLocation{IP: ip, Country: f.country}, nil
+}
+
+func TestChainFailsOver(t *testing.T) {
+	logger.Init()
+	primary := &fakeProvider{err: errors.New("connection refused")}
+	secondary := &fakeProvider{country: "Ukraine"}
+
+	chain := geoip.NewChain([]geoip.ChainProvider{
+		{Name: "ipapi", Service: primary},
+		{Name: "mmdb", Service: secondary},
+	}, geoip.ChainConfig{FailureThreshold: 3, Cooldown: time.Minute})
+
+	loc, err := chain.Lookup(context.Background(), "8.8.8.8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loc.Country != "Ukraine" || loc.Source != "mmdb" {
+		t.Fatalf("want answer from mmdb, got %+v", loc)
+	}
+}
+
+func TestChainNotFoundDoesNotTripBreaker(t *testing.T) {
+	logger.Init()
+	primary := &fakeProvider{err: fmt.Errorf("%w: private range", model.ErrLocationNotFound)}
+	secondary := &fakeProvider{country: "Ukraine"}
+
+	chain := geoip.NewChain([]geoip.ChainProvider{
+		{Name: "ipapi", Service: primary},
+		{Name: "cidr", Service: secondary},
+	}, geoip.ChainConfig{FailureThreshold: 1, Cooldown: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, err := chain.Lookup(context.Background(), "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if primary.calls != 3 {
+		t.Fatalf("want primary to stay in rotation, got %d calls", primary.calls)
+	}
+}
+
+func TestChainCircuitBreaker(t *testing.T) {
+	logger.Init()
+	primary := &fakeProvider{err: errors.New("503 Service Unavailable")}
+	secondary := &fakeProvider{country: "Ukraine"}
+
+	chain := geoip.NewChain([]geoip.ChainProvider{
+		{Name: "ipapi", Service: primary},
+		{Name: "mmdb", Service: secondary},
+	}, geoip.ChainConfig{FailureThreshold: 2, Cooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 5; i++ {
+		if _, err := chain.Lookup(context.Background(), "8.8.8.8"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Fatalf("want breaker to open after 2 failures, got %d calls", primary.calls)
+	}
+	if st := chain.Status()[0]; st.State != "open" {
+		t.Fatalf("want open breaker, got %+v", st)
+	}
+
+	// After the cooldown a single trial goes through and closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	primary.err = nil
+	primary.country = "United States"
+
+	loc, err := chain.Lookup(context.Background(), "8.8.8.8")
+	if err != nil || loc.Source != "ipapi" {
+		t.Fatalf("want half-open trial to succeed, got %+v, %v", loc, err)
+	}
+	if st := chain.Status()[0]; st.State != "closed" {
+		t.Fatalf("want closed breaker, got %+v", st)
+	}
+}
+
+func TestChainAllProvidersFail(t *testing.T) {
+	logger.Init()
+	chain := geoip.NewChain([]geoip.ChainProvider{
+		{Name: "a", Service: &fakeProvider{err: errors.New("boom")}},
+		{Name: "b", Service: &fakeProvider{err: errors.New("bang")}},
+	}, geoip.ChainConfig{FailureThreshold: 1, Cooldown: time.Minute})
+
+	if _, err := chain.Lookup(context.Background(), "8.8.8.8"); err == nil {
+		t.Fatal("want error when every provider fails")
+	}
+	if _, err := chain.Lookup(context.Background(), "8.8.8.8"); !errors.Is(err, geoip.ErrNoProviderAvailable) {
+		t.Fatalf("want ErrNoProviderAvailable with all breakers open, got %v", err)
+	}
+}
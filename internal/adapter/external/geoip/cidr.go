@@ -0,0 +1,308 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+// CIDRService resolves IPs to countries from RIR delegated-stats files
+// (delegated-*-extended format) or "cidr,country_code[,country]" CSV files,
+// loaded into in-memory radix trees. It needs no network access and no
+// third-party database, which makes it suitable for air-gapped deployments.
+type CIDRService struct {
+	paths []string
+	db    atomic.Pointer[cidrDB]
+}
+
+// CIDRStats describes the currently loaded database. MemoryBytes is an
+// estimate of the tree nodes and records, not counting Go runtime overhead.
+type CIDRStats struct {
+	IPv4Prefixes int       `json:"ipv4_prefixes"`
+	IPv6Prefixes int       `json:"ipv6_prefixes"`
+	Nodes        int       `json:"nodes"`
+	MemoryBytes  int       `json:"memory_bytes"`
+	LoadedAt     time.Time `json:"loaded_at"`
+}
+
+type cidrRecord struct {
+	countryCode string
+	country     string
+}
+
+type cidrDB struct {
+	v4, v6    radixTree
+	records   map[string]*cidrRecord
+	loadedAt  time.Time
+	buildDate time.Time
+}
+
+func NewCIDRService(paths []string) (*CIDRService, error) {
+	s := &CIDRService{paths: paths}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads all files again and atomically replaces the database.
+// Lookups keep using the previous version until the new one is complete;
+// on error the previous version stays in place.
+func (s *CIDRService) Reload() error {
+	db := &cidrDB{records: make(map[string]*cidrRecord)}
+	for _, path := range s.paths {
+		if err := db.loadFile(path); err != nil {
+			return fmt.Errorf("failed to load CIDR database %q: %w", path, err)
+		}
+	}
+	db.loadedAt = time.Now()
+	s.db.Store(db)
+
+	st := s.Stats()
+	logger.Log.Sugar().Infow("CIDR database loaded",
+		"paths", s.paths,
+		"ipv4_prefixes", st.IPv4Prefixes,
+		"ipv6_prefixes", st.IPv6Prefixes,
+		"memory_bytes", st.MemoryBytes,
+	)
+	return nil
+}
+
+func (s *CIDRService) Stats() CIDRStats {
+	db := s.db.Load()
+	nodes := db.v4.nodes + db.v6.nodes
+	mem := nodes * radixNodeSize
+	for code, rec := range db.records {
+		mem += cidrRecordSize + len(code) + len(rec.countryCode) + len(rec.country)
+	}
+	return CIDRStats{
+		IPv4Prefixes: db.v4.size,
+		IPv6Prefixes: db.v6.size,
+		Nodes:        nodes,
+		MemoryBytes:  mem,
+		LoadedAt:     db.loadedAt,
+	}
+}
+
+// Metadata reports the newest data date: the end date from RIR file headers,
+// or the modification time of CSV files.
+func (s *CIDRService) Metadata() DatabaseMetadata {
+	return DatabaseMetadata{Type: "cidr", BuildDate: s.db.Load().buildDate}
+}
+
+// Close is a no-op; the database lives entirely in memory.
+func (s *CIDRService) Close() error {
+	return nil
+}
+
+func (s *CIDRService) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+	addr = addr.Unmap()
+
+	db := s.db.Load()
+	tree := &db.v6
+	if addr.Is4() {
+		tree = &db.v4
+	}
+
+	rec := tree.lookup(addr)
+	if rec == nil {
+		return nil, fmt.Errorf("%w: %s is not in the CIDR database", model.ErrLocationNotFound, ip)
+	}
+
+	country := rec.country
+	if country == "" {
+		country = rec.countryCode
+	}
+	return &model.GeoLocation{
+		IP:          ip,
+		CountryCode: rec.countryCode,
+		Country:     country,
+		Source:      "cidr",
+	}, nil
+}
+
+func (db *cidrDB) insert(prefix netip.Prefix, countryCode, country string) {
+	countryCode = strings.ToUpper(countryCode)
+	rec, ok := db.records[countryCode]
+	if !ok || (rec.country == "" && country != "") {
+		rec = &cidrRecord{countryCode: countryCode, country: country}
+		db.records[countryCode] = rec
+	}
+
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4() {
+		db.v4.insert(prefix, rec)
+	} else {
+		db.v6.insert(prefix, rec)
+	}
+}
+
+// loadFile detects the file format from its first data line: RIR files are
+// pipe separated, everything else is treated as CSV.
+func (db *cidrDB) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if isRIRFormat(data) {
+		return db.loadRIR(bytes.NewReader(data))
+	}
+
+	if fi, err := os.Stat(path); err == nil {
+		db.touch(fi.ModTime())
+	}
+	return db.loadCSV(bytes.NewReader(data))
+}
+
+func (db *cidrDB) touch(t time.Time) {
+	if t.After(db.buildDate) {
+		db.buildDate = t
+	}
+}
+
+func isRIRFormat(data []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return strings.Contains(line, "|")
+	}
+	return false
+}
+
+func (db *cidrDB) loadCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := cr.FieldPos(0)
+		if len(row) < 2 {
+			return fmt.Errorf("line %d: want at least 2 fields, got %d", line, len(row))
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(row[0]))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		country := ""
+		if len(row) > 2 {
+			country = strings.TrimSpace(row[2])
+		}
+		db.insert(prefix, strings.TrimSpace(row[1]), country)
+	}
+}
+
+// loadRIR parses the RIR statistics exchange format:
+//
+//	registry|cc|type|start|value|date|status[|opaque-id]
+//
+// For ipv4 records value is a number of addresses, for ipv6 a prefix length.
+// Only allocated and assigned records carry a country.
+func (db *cidrDB) loadRIR(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		f := strings.Split(line, "|")
+		if _, err := strconv.ParseFloat(f[0], 64); err == nil && len(f) >= 6 {
+			// Version header: version|registry|serial|records|startdate|enddate|UTCoffset
+			if end, err := time.Parse("20060102", f[5]); err == nil {
+				db.touch(end)
+			}
+			continue
+		}
+		if len(f) < 7 {
+			// Summary line.
+			continue
+		}
+		cc, typ, start, value, status := f[1], f[2], f[3], f[4], f[6]
+		if (typ != "ipv4" && typ != "ipv6") || (status != "allocated" && status != "assigned") || cc == "" || cc == "*" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(start)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid value %q", lineNo, value)
+		}
+
+		if typ == "ipv6" {
+			prefix, err := addr.Prefix(int(n))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			db.insert(prefix, cc, "")
+			continue
+		}
+
+		if !addr.Is4() {
+			return fmt.Errorf("line %d: %s is not an IPv4 address", lineNo, start)
+		}
+		for _, prefix := range ipv4RangeToPrefixes(addr, n) {
+			db.insert(prefix, cc, "")
+		}
+	}
+	return sc.Err()
+}
+
+// ipv4RangeToPrefixes splits count addresses starting at start into the
+// smallest set of CIDR blocks; RIR ipv4 ranges need not be powers of two.
+func ipv4RangeToPrefixes(start netip.Addr, count uint64) []netip.Prefix {
+	a4 := start.As4()
+	cur := uint64(binary.BigEndian.Uint32(a4[:]))
+	end := cur + count
+
+	var out []netip.Prefix
+	for cur < end && cur <= 0xFFFFFFFF {
+		size := uint64(1) << bits.TrailingZeros32(uint32(cur))
+		for size > end-cur {
+			size >>= 1
+		}
+
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(cur))
+		out = append(out, netip.PrefixFrom(netip.AddrFrom4(b), 32-bits.TrailingZeros64(size)))
+		cur += size
+	}
+	return out
+}
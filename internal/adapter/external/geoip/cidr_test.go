@@ -0,0 +1,129 @@
+package geoip_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+const rirFixture = `2|ripencc|1735689600|4|19830101|20250101|+0100
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|1|summary
+ripencc|UA|ipv4|5.58.0.0|65536|20120306|allocated|a1b2
+ripencc|GB|ipv4|81.2.64.0|768|20040901|assigned|c3d4
+ripencc||ipv4|193.0.0.0|256|19930901|available
+ripencc|DE|ipv6|2a00:1450::|32|20090105|allocated|e5f6
+`
+
+const csvFixture = `# cidr,country_code,country
+10.0.0.0/8,US,United States
+10.1.0.0/16,UA,Ukraine
+10.1.2.0/24,PL
+2001:db8::/32,DE,Germany
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCIDRServiceLongestPrefix(t *testing.T) {
+	logger.Init()
+	svc, err := geoip.NewCIDRService([]string{writeFile(t, "cidr.csv", csvFixture)})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	cases := map[string]string{
+		"10.2.3.4":        "US",
+		"10.1.9.9":        "UA",
+		"10.1.2.3":        "PL",
+		"::ffff:10.1.2.3": "PL",
+		"2001:db8::1":     "DE",
+	}
+	for ip, want := range cases {
+		loc, err := svc.Lookup(context.Background(), ip)
+		if err != nil || loc.CountryCode != want {
+			t.Errorf("%s: want %s, got %+v, %v", ip, want, loc, err)
+		}
+	}
+
+	if _, err := svc.Lookup(context.Background(), "8.8.8.8"); !errors.Is(err, model.ErrLocationNotFound) {
+		t.Errorf("want ErrLocationNotFound, got %v", err)
+	}
+
+	st := svc.Stats()
+	if st.IPv4Prefixes != 3 || st.IPv6Prefixes != 1 || st.MemoryBytes == 0 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCIDRServiceRIRFormat(t *testing.T) {
+	logger.Init()
+	svc, err := geoip.NewCIDRService([]string{writeFile(t, "delegated-ripencc-extended", rirFixture)})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	cases := map[string]string{
+		"5.58.200.1":        "UA",
+		"81.2.64.1":         "GB",
+		"81.2.66.255":       "GB", // 768 addresses = /23 + /24
+		"2a00:1450:4001::1": "DE",
+	}
+	for ip, want := range cases {
+		loc, err := svc.Lookup(context.Background(), ip)
+		if err != nil || loc.CountryCode != want {
+			t.Errorf("%s: want %s, got %+v, %v", ip, want, loc, err)
+		}
+	}
+	for _, ip := range []string{"81.2.67.0", "193.0.0.1"} {
+		if _, err := svc.Lookup(context.Background(), ip); err == nil {
+			t.Errorf("%s: want not found", ip)
+		}
+	}
+
+	if st := svc.Stats(); st.IPv4Prefixes != 3 || st.IPv6Prefixes != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCIDRServiceReload(t *testing.T) {
+	logger.Init()
+	path := writeFile(t, "cidr.csv", "8.8.8.0/24,US\n")
+	svc, err := geoip.NewCIDRService([]string{path})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("8.8.8.0/24,CA\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if loc, _ := svc.Lookup(context.Background(), "8.8.8.8"); loc == nil || loc.CountryCode != "CA" {
+		t.Fatalf("want reloaded data, got %+v", loc)
+	}
+
+	// A broken file keeps the previous version.
+	if err := os.WriteFile(path, []byte("not a cidr,XX\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Reload(); err == nil {
+		t.Fatal("want reload error")
+	}
+	if loc, _ := svc.Lookup(context.Background(), "8.8.8.8"); loc == nil || loc.CountryCode != "CA" {
+		t.Fatalf("want previous data after failed reload, got %+v", loc)
+	}
+}
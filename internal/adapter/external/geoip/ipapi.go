@@ -1,47 +1,163 @@
 package geoip
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"ip_detector/internal/domain/model"
 	"ip_detector/internal/logger"
 )
 
+const ipAPIFields = "status,message,country,countryCode,regionName,city,zip,lat,lon,timezone,isp,as,query"
+
 type IPAPIService struct {
-	APIURL string
+	APIURL        string
+	BatchURL      string
+	Client        *http.Client
+	LookupTimeout time.Duration
+
+	quota      *ipAPIQuota
+	batchQuota *ipAPIQuota
+}
+
+// IPAPIConfig bounds how long a single ip-api call may take. Zero values
+// disable the corresponding limit. When the rate limit budget is exhausted a
+// lookup waits up to MaxQuotaWait for the next window before giving up.
+// BatchURL defaults to the /batch endpoint next to the API URL.
+type IPAPIConfig struct {
+	BatchURL       string
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	LookupTimeout  time.Duration
+	MaxQuotaWait   time.Duration
 }
 
 type ipAPIResponse struct {
-	Country string `json:"country"`
+	Status      string  `json:"status"`
+	Message     string  `json:"message"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"countryCode"`
+	RegionName  string  `json:"regionName"`
+	City        string  `json:"city"`
+	Zip         string  `json:"zip"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	Timezone    string  `json:"timezone"`
+	ISP         string  `json:"isp"`
+	AS          string  `json:"as"`
+	Query       string  `json:"query"`
 }
 
-func NewIPAPIService(apiURL string) *IPAPIService {
-	return &IPAPIService{APIURL: apiURL}
+func NewIPAPIService(apiURL string, cfg IPAPIConfig) *IPAPIService {
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.ConnectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	batchURL := cfg.BatchURL
+	if batchURL == "" {
+		batchURL = defaultBatchURL(apiURL)
+	}
+
+	return &IPAPIService{
+		APIURL:        apiURL,
+		BatchURL:      batchURL,
+		Client:        &http.Client{Transport: transport},
+		LookupTimeout: cfg.LookupTimeout,
+		quota:         newIPAPIQuota(cfg.MaxQuotaWait),
+		batchQuota:    newIPAPIQuota(cfg.MaxQuotaWait),
+	}
 }
 
-func (s *IPAPIService) GetCountryByIP(ip string) (string, error) {
-	url := fmt.Sprintf("%s/%s?fields=country", s.APIURL, ip)
+func (s *IPAPIService) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	if err := s.quota.acquire(ctx); err != nil {
+		logger.Log.Sugar().Warnw("GeoIP lookup rejected by local rate limit", "ip", ip, "error", err)
+		return nil, err
+	}
+
+	if s.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.LookupTimeout)
+		defer cancel()
+	}
+
+	url := fmt.Sprintf("%s/%s?fields=%s", s.APIURL, ip, ipAPIFields)
 	logger.Log.Sugar().Infow("requesting GeoIP", "url", url, "ip", ip)
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build IP API request: %w", err)
+	}
+
+	resp, err := s.Client.Do(req)
 	if err != nil {
 		logger.Log.Sugar().Errorw("failed to call GeoIP service", "error", err)
-		return "", fmt.Errorf("failed to request IP API: %w", err)
+		return nil, fmt.Errorf("failed to request IP API: %w", err)
 	}
 	defer resp.Body.Close()
 
+	reset := s.quota.update(resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		logger.Log.Sugar().Warnw("GeoIP rate limited", "ip", ip, "retry_after", reset)
+		return nil, &model.RateLimitError{Provider: "ip-api", RetryAfter: reset}
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		logger.Log.Sugar().Warnw("GeoIP returned non‑200", "status", resp.Status, "ip", ip)
-		return "", fmt.Errorf("IP API returned non-200 status: %s", resp.Status)
+		return nil, fmt.Errorf("IP API returned non-200 status: %s", resp.Status)
 	}
 
 	var data ipAPIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		logger.Log.Sugar().Errorw("failed to decode GeoIP response", "error", err)
-		return "", fmt.Errorf("failed to decode IP API response: %w", err)
+		return nil, fmt.Errorf("failed to decode IP API response: %w", err)
 	}
 
-	logger.Log.Sugar().Infow("GeoIP success", "ip", ip, "country", data.Country)
-	return data.Country, nil
+	if data.Status != "success" {
+		logger.Log.Sugar().Warnw("GeoIP lookup failed", "ip", ip, "message", data.Message)
+		return nil, fmt.Errorf("%w: IP API has no data for %s: %s", model.ErrLocationNotFound, ip, data.Message)
+	}
+
+	loc := data.toGeoLocation(ip)
+	logger.Log.Sugar().Infow("GeoIP success", "ip", ip, "country", loc.Country, "city", loc.City)
+	return loc, nil
+}
+
+func (d *ipAPIResponse) toGeoLocation(ip string) *model.GeoLocation {
+	lat, lon := d.Lat, d.Lon
+	return &model.GeoLocation{
+		IP:          ip,
+		CountryCode: d.CountryCode,
+		Country:     d.Country,
+		Region:      d.RegionName,
+		City:        d.City,
+		PostalCode:  d.Zip,
+		Latitude:    &lat,
+		Longitude:   &lon,
+		Timezone:    d.Timezone,
+		ASN:         parseASN(d.AS),
+		ISP:         d.ISP,
+		Source:      "ipapi",
+	}
+}
+
+// parseASN extracts the number from ip-api's "as" field, e.g. "AS15169 Google LLC".
+func parseASN(as string) uint32 {
+	num, _, _ := strings.Cut(strings.TrimPrefix(as, "AS"), " ")
+	n, err := strconv.ParseUint(num, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(n)
 }
@@ -0,0 +1,128 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+// ipAPIBatchSize is the largest batch ip-api's POST /batch accepts.
+const ipAPIBatchSize = 100
+
+// defaultBatchURL derives the batch endpoint from the single lookup one,
+// e.g. http://ip-api.com/json -> http://ip-api.com/batch.
+func defaultBatchURL(apiURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/json") + "/batch"
+}
+
+// NativeBatch is true: ip-api resolves up to 100 IPs per request.
+func (s *IPAPIService) NativeBatch() bool { return true }
+
+// LookupBatch resolves ips in chunks of up to 100 per request. A failed
+// chunk marks only its own IPs as failed; the remaining chunks are still
+// attempted unless the context is done or ip-api asks us to back off.
+func (s *IPAPIService) LookupBatch(ctx context.Context, ips []string) ([]model.GeoLookupResult, error) {
+	log := logger.Log.Sugar()
+	results := make([]model.GeoLookupResult, len(ips))
+
+	for start := 0; start < len(ips); start += ipAPIBatchSize {
+		end := min(start+ipAPIBatchSize, len(ips))
+		chunk := ips[start:end]
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		locs, err := s.lookupChunk(ctx, chunk)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			log.Warnw("GeoIP batch chunk failed", "from", start, "to", end, "error", err)
+
+			var rateLimited *model.RateLimitError
+			if errors.As(err, &rateLimited) {
+				end = len(ips)
+			}
+			for i := start; i < end; i++ {
+				results[i] = model.GeoLookupResult{IP: ips[i], Err: err}
+			}
+			if rateLimited != nil {
+				break
+			}
+			continue
+		}
+
+		for i, ip := range chunk {
+			results[start+i] = locs[i]
+			results[start+i].IP = ip
+		}
+	}
+
+	return results, nil
+}
+
+func (s *IPAPIService) lookupChunk(ctx context.Context, ips []string) ([]model.GeoLookupResult, error) {
+	if err := s.batchQuota.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	if s.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.LookupTimeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(ips)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode IP API batch: %w", err)
+	}
+
+	url := fmt.Sprintf("%s?fields=%s", s.BatchURL, ipAPIFields)
+	logger.Log.Sugar().Infow("requesting GeoIP batch", "url", url, "count", len(ips))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build IP API batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request IP API batch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	reset := s.batchQuota.update(resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &model.RateLimitError{Provider: "ip-api", RetryAfter: reset}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IP API batch returned non-200 status: %s", resp.Status)
+	}
+
+	var data []ipAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode IP API batch response: %w", err)
+	}
+	if len(data) != len(ips) {
+		return nil, fmt.Errorf("IP API batch returned %d results for %d IPs", len(data), len(ips))
+	}
+
+	results := make([]model.GeoLookupResult, len(ips))
+	for i, d := range data {
+		if d.Status != "success" {
+			results[i].Err = fmt.Errorf("%w: IP API has no data for %s: %s", model.ErrLocationNotFound, ips[i], d.Message)
+			continue
+		}
+		results[i].Location = d.toGeoLocation(ips[i])
+	}
+	return results, nil
+}
@@ -0,0 +1,92 @@
+package geoip
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+// ipAPIQuota tracks the request budget ip-api reports in its X-Rl (requests
+// left) and X-Ttl (seconds until the window resets) headers, so lookups can
+// wait for the next window instead of being answered with 429.
+type ipAPIQuota struct {
+	maxWait time.Duration
+
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	resetAt   time.Time
+}
+
+func newIPAPIQuota(maxWait time.Duration) *ipAPIQuota {
+	return &ipAPIQuota{maxWait: maxWait}
+}
+
+// acquire reserves one request from the current window. When the window is
+// exhausted it waits for the reset if that happens within maxWait, and fails
+// with a RateLimitError otherwise.
+func (q *ipAPIQuota) acquire(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		if !q.known || now.After(q.resetAt) {
+			q.known = false
+			q.mu.Unlock()
+			return nil
+		}
+		if q.remaining > 0 {
+			q.remaining--
+			q.mu.Unlock()
+			return nil
+		}
+		wait := q.resetAt.Sub(now)
+		q.mu.Unlock()
+
+		if wait > q.maxWait {
+			return &model.RateLimitError{Provider: "ip-api", RetryAfter: wait}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return &model.RateLimitError{Provider: "ip-api", RetryAfter: wait}
+		}
+
+		logger.Log.Sugar().Infow("ip-api quota exhausted, waiting for reset", "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// update records the budget reported by a response. It returns the time
+// until reset so callers can build a RateLimitError for 429 answers.
+func (q *ipAPIQuota) update(resp *http.Response) time.Duration {
+	ttl, ttlErr := strconv.Atoi(resp.Header.Get("X-Ttl"))
+	remaining, rlErr := strconv.Atoi(resp.Header.Get("X-Rl"))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		remaining, rlErr = 0, nil
+		if ttlErr != nil {
+			ttl, ttlErr = 60, nil
+		}
+	}
+	if ttlErr != nil || rlErr != nil {
+		return 0
+	}
+
+	reset := time.Duration(ttl) * time.Second
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.known = true
+	q.remaining = remaining
+	q.resetAt = time.Now().Add(reset)
+	return reset
+}
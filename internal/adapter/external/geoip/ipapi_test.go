@@ -0,0 +1,170 @@
+package geoip_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+// stallingServer never answers until the client goes away.
+func stallingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIPAPILookup(t *testing.T) {
+	logger.Init()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/8.8.8.8" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","country":"United States","countryCode":"US",
+			"regionName":"Virginia","city":"Ashburn","zip":"20149","lat":39.03,"lon":-77.5,
+			"timezone":"America/New_York","isp":"Google LLC","as":"AS15169 Google LLC","query":"8.8.8.8"}`)
+	}))
+	defer srv.Close()
+
+	svc := geoip.NewIPAPIService(srv.URL+"/json", geoip.IPAPIConfig{LookupTimeout: time.Second})
+	loc, err := svc.Lookup(context.Background(), "8.8.8.8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loc.CountryCode != "US" || loc.City != "Ashburn" || loc.ASN != 15169 || loc.ISP != "Google LLC" {
+		t.Fatalf("unexpected location: %+v", loc)
+	}
+}
+
+func TestIPAPILookupDeadline(t *testing.T) {
+	logger.Init()
+	srv := stallingServer(t)
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{LookupTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := svc.Lookup(context.Background(), "8.8.8.8")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("lookup was not aborted in time: %s", elapsed)
+	}
+}
+
+func TestIPAPIReadTimeout(t *testing.T) {
+	logger.Init()
+	srv := stallingServer(t)
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{ReadTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := svc.Lookup(context.Background(), "8.8.8.8"); err == nil {
+		t.Fatal("want error when the provider does not answer")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("lookup was not aborted in time: %s", elapsed)
+	}
+}
+
+func TestIPAPILookupCancelled(t *testing.T) {
+	logger.Init()
+	srv := stallingServer(t)
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := svc.Lookup(ctx, "8.8.8.8")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context canceled, got %v", err)
+	}
+}
+
+func TestIPAPIRespectsRateLimitHeaders(t *testing.T) {
+	logger.Init()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-Rl", "0")
+		w.Header().Set("X-Ttl", "30")
+		fmt.Fprint(w, `{"status":"success","country":"United States","countryCode":"US"}`)
+	}))
+	defer srv.Close()
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{MaxQuotaWait: time.Second})
+	if _, err := svc.Lookup(context.Background(), "8.8.8.8"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := svc.Lookup(context.Background(), "8.8.4.4")
+	var rateLimited *model.RateLimitError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("want RateLimitError, got %v", err)
+	}
+	if secs := rateLimited.RetryAfterSeconds(); secs < 29 || secs > 30 {
+		t.Fatalf("want retry after ~30s, got %d", secs)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("want exhausted budget to stop outgoing calls, got %d", got)
+	}
+}
+
+func TestIPAPIWaitsForShortReset(t *testing.T) {
+	logger.Init()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("X-Rl", "0")
+			w.Header().Set("X-Ttl", "1")
+		}
+		fmt.Fprint(w, `{"status":"success","country":"United States","countryCode":"US"}`)
+	}))
+	defer srv.Close()
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{MaxQuotaWait: 2 * time.Second})
+	if _, err := svc.Lookup(context.Background(), "8.8.8.8"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := svc.Lookup(context.Background(), "8.8.4.4"); err != nil {
+		t.Fatalf("want lookup to wait for the next window, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("want lookup to be delayed until reset, took %s", elapsed)
+	}
+}
+
+func TestIPAPITooManyRequests(t *testing.T) {
+	logger.Init()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ttl", "12")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	svc := geoip.NewIPAPIService(srv.URL, geoip.IPAPIConfig{})
+	_, err := svc.Lookup(context.Background(), "8.8.8.8")
+	var rateLimited *model.RateLimitError
+	if !errors.As(err, &rateLimited) || rateLimited.RetryAfterSeconds() != 12 {
+		t.Fatalf("want RateLimitError with 12s retry, got %v", err)
+	}
+}
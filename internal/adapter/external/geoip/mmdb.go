@@ -0,0 +1,121 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/logger"
+)
+
+// MMDBService answers lookups from a local MaxMind DB file (GeoLite2/GeoIP2
+// Country or City, DB-IP Lite) without any network calls.
+type MMDBService struct {
+	reader *maxminddb.Reader
+}
+
+type mmdbNames struct {
+	Names map[string]string `maxminddb:"names"`
+}
+
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []mmdbNames `maxminddb:"subdivisions"`
+	City         mmdbNames   `maxminddb:"city"`
+	Postal       struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+		TimeZone  string   `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+
+	// Present in GeoLite2-ASN and GeoIP2-ISP databases.
+	ASN   uint32 `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+	ISP   string `maxminddb:"isp"`
+}
+
+func NewMMDBService(path string) (*MMDBService, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MMDB %q: %w", path, err)
+	}
+
+	logger.Log.Sugar().Infow("MMDB loaded",
+		"path", path,
+		"type", reader.Metadata.DatabaseType,
+		"build", reader.Metadata.BuildTime(),
+	)
+	return &MMDBService{reader: reader}, nil
+}
+
+func (s *MMDBService) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q: %w", ip, err)
+	}
+
+	var record mmdbRecord
+	result := s.reader.Lookup(addr.Unmap())
+	if err := result.Decode(&record); err != nil {
+		logger.Log.Sugar().Errorw("failed to decode MMDB record", "ip", ip, "error", err)
+		return nil, fmt.Errorf("failed to decode MMDB record: %w", err)
+	}
+	if !result.Found() {
+		logger.Log.Sugar().Warnw("IP not found in MMDB", "ip", ip)
+		return nil, fmt.Errorf("%w: %s is not in MMDB", model.ErrLocationNotFound, ip)
+	}
+
+	loc := record.toGeoLocation(ip)
+	logger.Log.Sugar().Infow("MMDB lookup success", "ip", ip, "country", loc.Country, "city", loc.City)
+	return loc, nil
+}
+
+func (s *MMDBService) Metadata() DatabaseMetadata {
+	return DatabaseMetadata{
+		Type:      s.reader.Metadata.DatabaseType,
+		BuildDate: s.reader.Metadata.BuildTime(),
+	}
+}
+
+func (s *MMDBService) Close() error {
+	return s.reader.Close()
+}
+
+func (r *mmdbRecord) toGeoLocation(ip string) *model.GeoLocation {
+	loc := &model.GeoLocation{
+		IP:          ip,
+		CountryCode: r.Country.ISOCode,
+		Country:     r.Country.Names["en"],
+		City:        r.City.Names["en"],
+		PostalCode:  r.Postal.Code,
+		Latitude:    r.Location.Latitude,
+		Longitude:   r.Location.Longitude,
+		Timezone:    r.Location.TimeZone,
+		ASN:         r.ASN,
+		ISP:         r.ISP,
+		Source:      "mmdb",
+	}
+	if loc.Country == "" {
+		loc.Country = r.Country.ISOCode
+	}
+	if len(r.Subdivisions) > 0 {
+		loc.Region = r.Subdivisions[0].Names["en"]
+	}
+	if loc.ISP == "" {
+		loc.ISP = r.ASOrg
+	}
+	return loc
+}
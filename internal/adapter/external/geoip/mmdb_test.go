@@ -0,0 +1,154 @@
+package geoip_test
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/logger"
+)
+
+var updateFixture = flag.Bool("update", false, "regenerate the MMDB fixture in testdata")
+
+var mmdbFixture = filepath.Join("testdata", "GeoLite2-City-Test.mmdb")
+
+func cityRecord(iso, country, subdivISO, subdiv, city, postal, tz string, lat, lon float64) mmdbtype.Map {
+	rec := mmdbtype.Map{
+		"country": mmdbtype.Map{
+			"iso_code": mmdbtype.String(iso),
+			"names":    mmdbtype.Map{"en": mmdbtype.String(country)},
+		},
+		"location": mmdbtype.Map{
+			"latitude":  mmdbtype.Float64(lat),
+			"longitude": mmdbtype.Float64(lon),
+			"time_zone": mmdbtype.String(tz),
+		},
+	}
+	if city != "" {
+		rec["city"] = mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(city)}}
+	}
+	if subdiv != "" {
+		rec["subdivisions"] = mmdbtype.Slice{mmdbtype.Map{
+			"iso_code": mmdbtype.String(subdivISO),
+			"names":    mmdbtype.Map{"en": mmdbtype.String(subdiv)},
+		}}
+	}
+	if postal != "" {
+		rec["postal"] = mmdbtype.Map{"code": mmdbtype.String(postal)}
+	}
+	return rec
+}
+
+// writeMMDBFixture regenerates the checked-in fixture:
+//
+//	go test ./internal/adapter/external/geoip -run MMDB -update
+func writeMMDBFixture(t *testing.T) {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: "GeoLite2-City",
+		Description:  map[string]string{"en": "ip_detector test fixture"},
+		Languages:    []string{"en"},
+		RecordSize:   24,
+		BuildEpoch:   1735689600,
+	})
+	if err != nil {
+		t.Fatalf("new tree: %v", err)
+	}
+
+	records := map[string]mmdbtype.Map{
+		"81.2.69.0/24":   cityRecord("GB", "United Kingdom", "ENG", "England", "London", "EC2V", "Europe/London", 51.5142, -0.0931),
+		"8.8.8.0/24":     cityRecord("US", "United States", "CA", "California", "Mountain View", "94043", "America/Los_Angeles", 37.386, -122.0838),
+		"5.58.0.0/16":    cityRecord("UA", "Ukraine", "30", "Kyiv City", "Kyiv", "", "Europe/Kyiv", 50.4547, 30.5238),
+		"2001:4860::/32": cityRecord("US", "United States", "", "", "", "", "America/Chicago", 37.751, -97.822),
+	}
+	for cidr, rec := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("parse %s: %v", cidr, err)
+		}
+		if err := tree.Insert(network, rec); err != nil {
+			t.Fatalf("insert %s: %v", cidr, err)
+		}
+	}
+
+	f, err := os.Create(mmdbFixture)
+	if err != nil {
+		t.Fatalf("create fixture: %v", err)
+	}
+	defer f.Close()
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+}
+
+func openMMDBFixture(t *testing.T) *geoip.MMDBService {
+	t.Helper()
+	logger.Init()
+
+	if *updateFixture {
+		writeMMDBFixture(t)
+	}
+
+	svc, err := geoip.NewMMDBService(mmdbFixture)
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+func TestMMDBLookupCountry(t *testing.T) {
+	svc := openMMDBFixture(t)
+
+	cases := map[string]string{
+		"81.2.69.142":          "United Kingdom",
+		"8.8.8.8":              "United States",
+		"5.58.10.1":            "Ukraine",
+		"2001:4860:4860::8888": "United States",
+		"::ffff:8.8.8.4":       "United States",
+	}
+	for ip, want := range cases {
+		loc, err := svc.Lookup(context.Background(), ip)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", ip, err)
+		}
+		if loc.Country != want {
+			t.Errorf("%s: want %q, got %q", ip, want, loc.Country)
+		}
+	}
+}
+
+func TestMMDBLookupCity(t *testing.T) {
+	svc := openMMDBFixture(t)
+
+	loc, err := svc.Lookup(context.Background(), "81.2.69.142")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loc.CountryCode != "GB" || loc.Region != "England" || loc.City != "London" ||
+		loc.PostalCode != "EC2V" || loc.Timezone != "Europe/London" {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+	if loc.Latitude == nil || loc.Longitude == nil || *loc.Latitude != 51.5142 || *loc.Longitude != -0.0931 {
+		t.Errorf("unexpected coordinates: %v, %v", loc.Latitude, loc.Longitude)
+	}
+}
+
+func TestMMDBUnknownIP(t *testing.T) {
+	svc := openMMDBFixture(t)
+
+	if _, err := svc.Lookup(context.Background(), "1.1.1.1"); err == nil {
+		t.Fatal("want error for IP missing from the database")
+	}
+	if _, err := svc.Lookup(context.Background(), "not_ip"); err == nil {
+		t.Fatal("want error for malformed IP")
+	}
+}
@@ -0,0 +1,137 @@
+package geoip
+
+import (
+	"net/netip"
+	"unsafe"
+)
+
+// radixTree is a path-compressed binary (patricia) trie mapping IP prefixes
+// to records. Lookups return the record of the longest matching prefix.
+// IPv4 and IPv6 use separate trees so IPv4 keys are only 32 bits deep.
+type radixTree struct {
+	root  *radixNode
+	nodes int
+	size  int
+}
+
+type radixNode struct {
+	key   [16]byte
+	bits  int
+	rec   *cidrRecord
+	child [2]*radixNode
+}
+
+var radixNodeSize = int(unsafe.Sizeof(radixNode{}))
+
+func addrKey(addr netip.Addr) [16]byte {
+	if addr.Is4() {
+		var k [16]byte
+		a4 := addr.As4()
+		copy(k[:], a4[:])
+		return k
+	}
+	return addr.As16()
+}
+
+func bitAt(key [16]byte, i int) int {
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// commonBits returns how many leading bits a and b share, up to limit.
+func commonBits(a, b [16]byte, limit int) int {
+	n := 0
+	for i := 0; i < 16 && n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return min(n, limit)
+}
+
+func maskKey(key [16]byte, bits int) [16]byte {
+	var out [16]byte
+	for i := 0; i < 16 && bits > 0; i++ {
+		if bits >= 8 {
+			out[i] = key[i]
+			bits -= 8
+			continue
+		}
+		out[i] = key[i] & (0xFF << (8 - uint(bits)))
+		bits = 0
+	}
+	return out
+}
+
+// insert stores rec for prefix, replacing any record already stored for
+// exactly the same prefix.
+func (t *radixTree) insert(prefix netip.Prefix, rec *cidrRecord) {
+	key := maskKey(addrKey(prefix.Addr()), prefix.Bits())
+	bits := prefix.Bits()
+
+	p := &t.root
+	for {
+		n := *p
+		if n == nil {
+			*p = &radixNode{key: key, bits: bits, rec: rec}
+			t.nodes++
+			t.size++
+			return
+		}
+
+		common := commonBits(n.key, key, min(n.bits, bits))
+		if common < n.bits {
+			// Split: a new inner node holds the shared part of both keys.
+			parent := &radixNode{key: maskKey(key, common), bits: common}
+			parent.child[bitAt(n.key, common)] = n
+			t.nodes++
+			if common == bits {
+				parent.rec = rec
+				t.size++
+			} else {
+				parent.child[bitAt(key, common)] = &radixNode{key: key, bits: bits, rec: rec}
+				t.nodes++
+				t.size++
+			}
+			*p = parent
+			return
+		}
+
+		if n.bits == bits {
+			if n.rec == nil {
+				t.size++
+			}
+			n.rec = rec
+			return
+		}
+		p = &n.child[bitAt(key, n.bits)]
+	}
+}
+
+func (t *radixTree) lookup(addr netip.Addr) *cidrRecord {
+	key := addrKey(addr)
+	maxBits := addr.BitLen()
+
+	var best *cidrRecord
+	for n := t.root; n != nil; {
+		if commonBits(n.key, key, n.bits) < n.bits {
+			break
+		}
+		if n.rec != nil {
+			best = n.rec
+		}
+		if n.bits >= maxBits {
+			break
+		}
+		n = n.child[bitAt(key, n.bits)]
+	}
+	return best
+}
+
+var cidrRecordSize = int(unsafe.Sizeof(cidrRecord{}))
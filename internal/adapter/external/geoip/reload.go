@@ -0,0 +1,299 @@
+package geoip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// Database is a GeoIP provider loaded from local files that Reloadable can
+// replace at runtime.
+type Database interface {
+	port.GeoIPService
+	Metadata() DatabaseMetadata
+	Close() error
+}
+
+// DatabaseMetadata is what a Database knows about its own contents.
+type DatabaseMetadata struct {
+	Type      string
+	BuildDate time.Time
+}
+
+// ReloadConfig configures Reloadable. Canaries are IPs every database
+// version, including the first, must resolve before it is used.
+type ReloadConfig struct {
+	Paths        []string
+	PollInterval time.Duration
+	Canaries     []string
+}
+
+// Reloadable serves lookups from a Database and replaces it when its files
+// change on disk or the process receives SIGHUP. A new version is loaded and
+// validated in the background; lookups already running on the old version
+// finish before it is closed.
+type Reloadable struct {
+	load func() (Database, error)
+	cfg  ReloadConfig
+
+	mu       sync.RWMutex
+	current  *dbGeneration
+	onReload []func()
+
+	reloadMu  sync.Mutex
+	stamp     fileStamp
+	reloads   int
+	lastError string
+}
+
+type dbGeneration struct {
+	db       Database
+	checksum string
+	loadedAt time.Time
+	inflight sync.WaitGroup
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewReloadable(load func() (Database, error), cfg ReloadConfig) (*Reloadable, error) {
+	r := &Reloadable{load: load, cfg: cfg}
+
+	gen, stamp, err := r.loadGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkCanaries(gen.db); err != nil {
+		_ = gen.db.Close()
+		return nil, err
+	}
+	r.current = gen
+	r.stamp = stamp
+	return r, nil
+}
+
+func (r *Reloadable) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	r.mu.RLock()
+	gen := r.current
+	gen.inflight.Add(1)
+	r.mu.RUnlock()
+	defer gen.inflight.Done()
+
+	return gen.db.Lookup(ctx, ip)
+}
+
+// OnReload registers fn to be called after a new version was swapped in.
+func (r *Reloadable) OnReload(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onReload = append(r.onReload, fn)
+}
+
+// Watch polls the database files every PollInterval and reloads on change
+// or SIGHUP until ctx is done.
+func (r *Reloadable) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if r.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(r.cfg.PollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Log.Sugar().Infow("SIGHUP received, reloading GeoIP database", "paths", r.cfg.Paths)
+			_ = r.Reload(true)
+		case <-tick:
+			_ = r.Reload(false)
+		}
+	}
+}
+
+// Reload loads the database again if its files changed (or always when
+// force is set) and swaps it in once the canary lookups pass.
+func (r *Reloadable) Reload(force bool) error {
+	log := logger.Log.Sugar()
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	stamp, err := statFiles(r.cfg.Paths)
+	if err != nil {
+		return r.fail(err)
+	}
+	if !force && stamp == r.stamp {
+		return nil
+	}
+
+	gen, stamp, err := r.loadGeneration()
+	if err != nil {
+		return r.fail(err)
+	}
+
+	r.mu.RLock()
+	unchanged := gen.checksum == r.current.checksum
+	r.mu.RUnlock()
+	if unchanged && !force {
+		r.stamp = stamp
+		_ = gen.db.Close()
+		return nil
+	}
+
+	if err := r.checkCanaries(gen.db); err != nil {
+		_ = gen.db.Close()
+		return r.fail(err)
+	}
+
+	r.mu.Lock()
+	old := r.current
+	r.current = gen
+	hooks := r.onReload
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	r.stamp = stamp
+	r.reloads++
+	r.lastError = ""
+	log.Infow("GeoIP database swapped", "paths", r.cfg.Paths, "version", shortChecksum(gen.checksum))
+
+	go func() {
+		old.inflight.Wait()
+		if err := old.db.Close(); err != nil {
+			log.Warnw("failed to close old GeoIP database", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (r *Reloadable) DatabaseInfo() []model.GeoIPDatabaseInfo {
+	r.mu.RLock()
+	gen := r.current
+	r.mu.RUnlock()
+
+	r.reloadMu.Lock()
+	reloads, lastError := r.reloads, r.lastError
+	r.reloadMu.Unlock()
+
+	meta := gen.db.Metadata()
+	return []model.GeoIPDatabaseInfo{{
+		Type:      meta.Type,
+		Version:   shortChecksum(gen.checksum),
+		BuildDate: meta.BuildDate,
+		Paths:     r.cfg.Paths,
+		LoadedAt:  gen.loadedAt,
+		Reloads:   reloads,
+		LastError: lastError,
+	}}
+}
+
+func (r *Reloadable) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current.inflight.Wait()
+	return r.current.db.Close()
+}
+
+func (r *Reloadable) loadGeneration() (*dbGeneration, fileStamp, error) {
+	stamp, err := statFiles(r.cfg.Paths)
+	if err != nil {
+		return nil, fileStamp{}, err
+	}
+	sum, err := checksumFiles(r.cfg.Paths)
+	if err != nil {
+		return nil, fileStamp{}, err
+	}
+
+	db, err := r.load()
+	if err != nil {
+		return nil, fileStamp{}, err
+	}
+	return &dbGeneration{db: db, checksum: sum, loadedAt: time.Now()}, stamp, nil
+}
+
+func (r *Reloadable) checkCanaries(db Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, ip := range r.cfg.Canaries {
+		loc, err := db.Lookup(ctx, ip)
+		if err != nil {
+			return fmt.Errorf("canary lookup of %s failed: %w", ip, err)
+		}
+		if loc.CountryCode == "" && loc.Country == "" {
+			return fmt.Errorf("canary lookup of %s returned no country", ip)
+		}
+	}
+	return nil
+}
+
+// fail must be called with reloadMu held.
+func (r *Reloadable) fail(err error) error {
+	r.lastError = err.Error()
+	logger.Log.Sugar().Errorw("GeoIP database reload failed, keeping current version", "paths", r.cfg.Paths, "error", err)
+	return err
+}
+
+// statFiles combines the latest mtime and total size of paths; any change
+// triggers a checksum comparison.
+func statFiles(paths []string) (fileStamp, error) {
+	var st fileStamp
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return fileStamp{}, err
+		}
+		if fi.ModTime().After(st.modTime) {
+			st.modTime = fi.ModTime()
+		}
+		st.size += fi.Size()
+	}
+	return st, nil
+}
+
+func checksumFiles(paths []string) (string, error) {
+	h := sha256.New()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func shortChecksum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
@@ -0,0 +1,101 @@
+package geoip_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/logger"
+)
+
+func newReloadableCIDR(t *testing.T, path string, canaries ...string) *geoip.Reloadable {
+	t.Helper()
+	r, err := geoip.NewReloadable(func() (geoip.Database, error) {
+		return geoip.NewCIDRService([]string{path})
+	}, geoip.ReloadConfig{Paths: []string{path}, Canaries: canaries})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	return r
+}
+
+// rewrite replaces the file content and bumps its mtime so the change is
+// seen even on filesystems with coarse timestamps.
+func rewrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadableSwapsOnChange(t *testing.T) {
+	logger.Init()
+	path := writeFile(t, "cidr.csv", "10.0.0.0/8,US\n")
+	r := newReloadableCIDR(t, path, "10.1.1.1")
+
+	purged := 0
+	r.OnReload(func() { purged++ })
+
+	before := r.DatabaseInfo()[0]
+	if before.Type != "cidr" || before.Version == "" {
+		t.Fatalf("unexpected info: %+v", before)
+	}
+
+	if err := r.Reload(false); err != nil || purged != 0 {
+		t.Fatalf("unchanged file must not reload: err=%v hooks=%d", err, purged)
+	}
+
+	rewrite(t, path, "10.0.0.0/8,UA\n")
+	if err := r.Reload(false); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	loc, err := r.Lookup(context.Background(), "10.1.1.1")
+	if err != nil || loc.CountryCode != "UA" {
+		t.Fatalf("want UA after reload, got %+v, %v", loc, err)
+	}
+
+	after := r.DatabaseInfo()[0]
+	if after.Version == before.Version || after.Reloads != 1 || purged != 1 {
+		t.Errorf("unexpected info after reload: %+v (hooks=%d)", after, purged)
+	}
+}
+
+func TestNewReloadableChecksCanaries(t *testing.T) {
+	logger.Init()
+	path := writeFile(t, "cidr.csv", "192.168.0.0/16,UA\n")
+	_, err := geoip.NewReloadable(func() (geoip.Database, error) {
+		return geoip.NewCIDRService([]string{path})
+	}, geoip.ReloadConfig{Paths: []string{path}, Canaries: []string{"10.1.1.1"}})
+	if err == nil {
+		t.Fatal("expected the first load to fail the canary check")
+	}
+}
+
+func TestReloadableKeepsOldVersionWhenCanaryFails(t *testing.T) {
+	logger.Init()
+	path := writeFile(t, "cidr.csv", "10.0.0.0/8,US\n")
+	r := newReloadableCIDR(t, path, "10.1.1.1")
+
+	rewrite(t, path, "192.168.0.0/16,UA\n")
+	if err := r.Reload(false); err == nil {
+		t.Fatal("expected canary failure")
+	}
+
+	loc, err := r.Lookup(context.Background(), "10.1.1.1")
+	if err != nil || loc.CountryCode != "US" {
+		t.Fatalf("old version must keep serving, got %+v, %v", loc, err)
+	}
+
+	info := r.DatabaseInfo()[0]
+	if info.Reloads != 0 || info.LastError == "" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ip_detector/internal/adapter/http/middleware"
+	"ip_detector/internal/adapter/http/problem"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/domain/model"
+)
+
+// reenrichDefaultLimit bounds POST /admin/reenrich when no limit is given,
+// since the request waits for every lookup.
+const reenrichDefaultLimit = 100
+
+type AdminHandler struct {
+	lookup           *service.LookupService
+	reenrich         *service.ReenrichService
+	revocations      *service.RevocationService
+	reenrichInterval time.Duration
+}
+
+func NewAdminHandler(lookup *service.LookupService, reenrich *service.ReenrichService, revocations *service.RevocationService, reenrichInterval time.Duration) *AdminHandler {
+	return &AdminHandler{lookup: lookup, reenrich: reenrich, revocations: revocations, reenrichInterval: reenrichInterval}
+}
+
+type reenrichRequest struct {
+	EmptyCountry  bool     `json:"empty_country"   example:"true"`
+	OlderThanDays int      `json:"older_than_days" example:"30"`
+	IDs           []string `json:"ids"`
+	Limit         int      `json:"limit"           example:"100"`
+	Apply         bool     `json:"apply"           example:"false"`
+}
+
+// ---------------- GeoIPDatabases ----------------
+
+// GeoIPDatabases godoc
+// @Summary      Loaded GeoIP databases
+// @Description  Lists the file-based GeoIP databases currently in use with their version, build date and reload state
+// @Tags         admin
+// @Security     BearerAuth
+// @Produce      json
+// @Success      200  {array}   model.GeoIPDatabaseInfo
+// @Failure      401,403  {object}  problem.Problem
+// @Router       /admin/geoip [get]
+func (h *AdminHandler) GeoIPDatabases(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+	log.Infow("geoip database info request")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.lookup.DatabaseInfo())
+}
+
+// ---------------- GeoIPCache ----------------
+
+// GeoIPCache godoc
+// @Summary      GeoIP cache statistics
+// @Description  Reports the hits, misses and current size of the GeoIP lookup cache
+// @Tags         admin
+// @Security     BearerAuth
+// @Produce      json
+// @Success      200  {object}  model.GeoIPCacheStats
+// @Failure      401,403,404  {object}  problem.Problem
+// @Router       /admin/geoip/cache [get]
+func (h *AdminHandler) GeoIPCache(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+	log.Infow("geoip cache stats request")
+
+	stats, ok := h.lookup.CacheStats()
+	if !ok {
+		problem.Error(w, r, http.StatusNotFound, "GeoIP cache is disabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// ---------------- Reenrich ----------------
+
+// Reenrich godoc
+// @Summary      Re-enrich users
+// @Description  Runs users with an empty country, enriched more than older_than_days ago, or listed in ids
+// @Description  through the GeoIP provider again and reports the changed countries. Without apply nothing
+// @Description  is written (dry run). At most limit users (default 100) are processed per request.
+// @Tags         admin
+// @Security     BearerAuth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      reenrichRequest  true  "Selection"
+// @Success      200      {object}  model.ReenrichReport
+// @Failure      400,401,403,500  {object}  problem.Problem
+// @Router       /admin/reenrich [post]
+func (h *AdminHandler) Reenrich(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+
+	var input reenrichRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		log.Warnw("invalid reenrich request", "error", err)
+		problem.Error(w, r, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	sel := model.ReenrichSelector{EmptyCountry: input.EmptyCountry, IDs: input.IDs, Limit: input.Limit}
+	if input.OlderThanDays > 0 {
+		before := time.Now().AddDate(0, 0, -input.OlderThanDays)
+		sel.EnrichedBefore = &before
+	}
+	if !sel.EmptyCountry && sel.EnrichedBefore == nil && len(sel.IDs) == 0 {
+		problem.Error(w, r, http.StatusBadRequest, "select users with empty_country, older_than_days or ids")
+		return
+	}
+	if sel.Limit <= 0 {
+		sel.Limit = reenrichDefaultLimit
+	}
+	log.Infow("reenrich request", "selector", sel, "apply", input.Apply)
+
+	report, err := h.reenrich.Run(r.Context(), service.ReenrichOptions{
+		Selector: sel,
+		Apply:    input.Apply,
+		Interval: h.reenrichInterval,
+	})
+	if err != nil {
+		log.Errorw("reenrich failed", "error", err)
+		problem.Error(w, r, http.StatusInternalServerError, "re-enrichment failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// ---------------- RevokeSessions ----------------
+
+// RevokeSessions godoc
+// @Summary      Revoke all sessions of a user
+// @Description  Invalidates every access token issued to the user so far and all of the user's refresh tokens.
+// @Description  The user has to log in again.
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        id   path      string  true  "User ID"
+// @Success      204
+// @Failure      400,401,403,404,500  {object}  problem.Problem
+// @Router       /admin/users/{id}/revoke-sessions [post]
+func (h *AdminHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log := middleware.Logger(r.Context())
+	log.Infow("revoke sessions request", "id", id)
+
+	if err := h.revocations.RevokeSessions(r.Context(), id); err != nil {
+		if status := writeError(w, r, err); status >= http.StatusInternalServerError {
+			log.Errorw("revoke sessions failed", "id", id, "error", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"ip_detector/internal/adapter/http/middleware"
+	"ip_detector/internal/adapter/http/problem"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/auth"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required" example:"b3BhcXVlLXJlZnJlc2gtdG9rZW4..."`
+}
+
+type AuthHandler struct {
+	tokens *service.TokenService
+	keys   *auth.KeySet
+}
+
+func NewAuthHandler(tokens *service.TokenService, keys *auth.KeySet) *AuthHandler {
+	return &AuthHandler{tokens: tokens, keys: keys}
+}
+
+// ---------------- Refresh ----------------
+
+// Refresh godoc
+// @Summary      Refresh Tokens
+// @Description  Exchanges a refresh token for a new access token and refresh token. The old refresh token
+// @Description  stops working; presenting it again revokes the whole session.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      refreshRequest  true  "Refresh token from /login or a previous refresh"
+// @Success      200      {object}  model.TokenPair
+// @Failure      400,401,500  {object}  problem.Problem
+// @Router       /token/refresh [post]
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+	log.Infow("refresh request received")
+
+	input, ok := decodeRefreshRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.tokens.Refresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		if status := writeError(w, r, err); status >= http.StatusInternalServerError {
+			log.Errorw("refresh failed", "error", err)
+		} else {
+			log.Warnw("refresh rejected", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tokens)
+}
+
+// ---------------- Logout ----------------
+
+// Logout godoc
+// @Summary      Logout
+// @Description  Revokes the session of the refresh token: it and every refresh token rotated from the same
+// @Description  login stop working. An access token sent as Bearer token is revoked as well.
+// @Tags         auth
+// @Accept       json
+// @Param        Authorization  header  string  false  "Bearer access token to revoke"
+// @Param        payload  body      refreshRequest  true  "Refresh token of the session"
+// @Success      204
+// @Failure      400,500  {object}  problem.Problem
+// @Router       /logout [post]
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+	log.Infow("logout request received")
+
+	input, ok := decodeRefreshRequest(w, r)
+	if !ok {
+		return
+	}
+
+	accessToken, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := h.tokens.Logout(r.Context(), input.RefreshToken, accessToken); err != nil {
+		log.Errorw("logout failed", "error", err)
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------------- JWKS ----------------
+
+// JWKS godoc
+// @Summary      JSON Web Key Set
+// @Description  Public keys that verify access tokens, matched by the token's "kid" header. Lists the
+// @Description  signing key and keys kept for verification during a rotation; empty when tokens are
+// @Description  signed with the shared HMAC secret.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  auth.JWKS
+// @Router       /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(h.keys.JWKS())
+}
+
+func decodeRefreshRequest(w http.ResponseWriter, r *http.Request) (refreshRequest, bool) {
+	log := middleware.Logger(r.Context())
+
+	var input refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		log.Warnw("invalid JSON", "error", err)
+		problem.Error(w, r, http.StatusBadRequest, "invalid JSON")
+		return input, false
+	}
+	if err := validate.Struct(input); err != nil {
+		log.Warnw("validation failed", "error", err)
+		problem.Validation(w, r, err)
+		return input, false
+	}
+	return input, true
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"ip_detector/internal/adapter/http/problem"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/domain/model"
+)
+
+// validate checks request bodies and reports fields by their JSON name.
+var validate = func() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}()
+
+// errorProblem maps an error from the service layer to a problem whose
+// detail is safe to show to clients. Unknown errors become a 500 without
+// details; handlers log the original error.
+func errorProblem(err error) *problem.Problem {
+	var reserved *model.ReservedIPError
+	var rateLimited *model.RateLimitError
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return problem.New(http.StatusNotFound, "not found")
+	case errors.Is(err, model.ErrInvalidRefreshToken):
+		return problem.New(http.StatusUnauthorized, "invalid or expired refresh token, log in again")
+	case errors.Is(err, model.ErrRefreshTokenReused):
+		return problem.New(http.StatusUnauthorized, "refresh token was already used, session revoked; log in again")
+	case errors.Is(err, model.ErrForbidden):
+		return problem.New(http.StatusForbidden, "not allowed to act on this user")
+	case errors.Is(err, model.ErrDuplicateEmail):
+		p := problem.New(http.StatusConflict, "email already registered")
+		p.Type = problem.TypeConflict
+		return p
+	case errors.Is(err, model.ErrInvalidID):
+		return problem.New(http.StatusBadRequest, "invalid ID")
+	case errors.Is(err, model.ErrInvalidCursor):
+		return problem.New(http.StatusBadRequest, "invalid cursor")
+	case errors.Is(err, service.ErrInvalidIP):
+		return problem.New(http.StatusBadRequest, "invalid IP address")
+	case errors.As(err, &reserved):
+		p := problem.New(http.StatusUnprocessableEntity, reserved.Error())
+		p.Type = problem.TypeReservedIP
+		return p
+	case errors.Is(err, model.ErrLocationNotFound):
+		return problem.New(http.StatusNotFound, "no location found for IP")
+	case errors.As(err, &rateLimited):
+		p := problem.New(http.StatusServiceUnavailable, "geolocation temporarily unavailable, retry later")
+		p.Type = problem.TypeRateLimited
+		return p
+	default:
+		return problem.New(http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// writeError responds with the problem for err, adding Retry-After for
+// rate limits, and returns the status.
+func writeError(w http.ResponseWriter, r *http.Request, err error) int {
+	p := errorProblem(err)
+
+	var rateLimited *model.RateLimitError
+	if errors.As(err, &rateLimited) {
+		w.Header().Set("Retry-After", strconv.Itoa(rateLimited.RetryAfterSeconds()))
+	}
+	problem.Write(w, r, p)
+	return p.Status
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"ip_detector/internal/adapter/http/middleware"
+	"ip_detector/internal/adapter/http/problem"
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/domain/model"
+)
+
+// BulkConfig limits POST /lookup/bulk.
+type BulkConfig struct {
+	MaxIPs      int
+	Concurrency int
+}
+
+const (
+	// bulkBytesPerIP is the body size allowed per IP: the longest IPv6
+	// notation, quoted, with separator and some whitespace.
+	bulkBytesPerIP = 64
+	// defaultBulkMaxBytes caps the body when MaxIPs is unlimited.
+	defaultBulkMaxBytes = 8 << 20
+)
+
+// maxBytes is the largest accepted request body.
+func (c BulkConfig) maxBytes() int64 {
+	if c.MaxIPs <= 0 {
+		return defaultBulkMaxBytes
+	}
+	return int64(c.MaxIPs)*bulkBytesPerIP + 1024
+}
+
+type LookupHandler struct {
+	service *service.LookupService
+	bulk    BulkConfig
+}
+
+func NewLookupHandler(service *service.LookupService, bulk BulkConfig) *LookupHandler {
+	return &LookupHandler{service: service, bulk: bulk}
+}
+
+type bulkLookupLine struct {
+	IP       string             `json:"ip"`
+	Location *model.GeoLocation `json:"location,omitempty"`
+	Status   int                `json:"status"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// ---------------- LookupIP ----------------
+
+// LookupIP godoc
+// @Summary      Geolocate an IP
+// @Description  Resolves the given IPv4 or IPv6 address through the configured GeoIP provider
+// @Tags         lookup
+// @Produce      json
+// @Param        ip   path      string  true  "IP address"
+// @Success      200  {object}  model.GeoLocation
+// @Failure      400,404,422,503  {object}  problem.Problem
+// @Router       /lookup/{ip} [get]
+func (h *LookupHandler) LookupIP(w http.ResponseWriter, r *http.Request) {
+	ip := mux.Vars(r)["ip"]
+	log := middleware.Logger(r.Context())
+	log.Infow("lookup request", "ip", ip)
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		log.Warnw("invalid IP format", "ip", ip)
+		problem.Error(w, r, http.StatusBadRequest, "invalid IP address")
+		return
+	}
+
+	h.lookup(w, r, addr.Unmap().String())
+}
+
+// ---------------- LookupSelf ----------------
+
+// LookupSelf godoc
+// @Summary      Geolocate the caller
+// @Description  Resolves the IP the request came from
+// @Tags         lookup
+// @Produce      json
+// @Success      200  {object}  model.GeoLocation
+// @Failure      400,404,422,503  {object}  problem.Problem
+// @Router       /lookup [get]
+func (h *LookupHandler) LookupSelf(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+
+	ip, ok := middleware.ClientIPFromContext(r.Context())
+	if !ok {
+		log.Warnw("cannot determine client IP", "remote_addr", r.RemoteAddr)
+		problem.Error(w, r, http.StatusBadRequest, "cannot determine client IP")
+		return
+	}
+	log.Infow("self lookup request", "ip", ip)
+
+	h.lookup(w, r, ip)
+}
+
+func (h *LookupHandler) lookup(w http.ResponseWriter, r *http.Request, ip string) {
+	log := middleware.Logger(r.Context())
+
+	loc, err := h.service.Lookup(r.Context(), ip)
+	if err != nil {
+		p := lookupProblem(err)
+		if p.Status == http.StatusServiceUnavailable {
+			log.Errorw("lookup failed", "ip", ip, "error", err)
+		}
+		var rateLimited *model.RateLimitError
+		if errors.As(err, &rateLimited) {
+			w.Header().Set("Retry-After", strconv.Itoa(rateLimited.RetryAfterSeconds()))
+		}
+		problem.Write(w, r, p)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(loc)
+}
+
+// ---------------- BulkLookup ----------------
+
+// BulkLookup godoc
+// @Summary      Geolocate many IPs
+// @Description  Accepts a JSON array of IPs (application/json) or one IP per line (application/x-ndjson)
+// @Description  and streams one NDJSON result line per IP, in input order. Failed lookups are reported
+// @Description  per line with their HTTP-like status instead of failing the whole request.
+// @Tags         lookup
+// @Security     BearerAuth
+// @Accept       json
+// @Accept       x-ndjson
+// @Produce      x-ndjson
+// @Param        payload  body      []string  true  "IP addresses"
+// @Success      200      {object}  bulkLookupLine
+// @Failure      400,401,413  {object}  problem.Problem
+// @Router       /lookup/bulk [post]
+func (h *LookupHandler) BulkLookup(w http.ResponseWriter, r *http.Request) {
+	log := middleware.Logger(r.Context())
+
+	ips, status, err := h.readBulkInput(w, r)
+	if err != nil {
+		log.Warnw("invalid bulk lookup input", "error", err)
+		problem.Error(w, r, status, err.Error())
+		return
+	}
+	log.Infow("bulk lookup request", "count", len(ips))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+
+	err = h.service.LookupMany(r.Context(), ips, h.bulk.Concurrency, func(res model.GeoLookupResult) error {
+		line := bulkLookupLine{IP: res.IP, Location: res.Location, Status: http.StatusOK}
+		if res.Err != nil {
+			p := lookupProblem(res.Err)
+			line.Status, line.Error = p.Status, p.Detail
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err != nil {
+		log.Warnw("bulk lookup aborted", "error", err)
+		return
+	}
+	log.Infow("bulk lookup finished", "count", len(ips))
+}
+
+// readBulkInput reads a JSON array or NDJSON list of IPs, enforcing the
+// configured maximum. Both formats are read incrementally, and the body size
+// is capped, so an oversized request is rejected before it is buffered.
+func (h *LookupHandler) readBulkInput(w http.ResponseWriter, r *http.Request) ([]string, int, error) {
+	var ips []string
+	tooMany := fmt.Errorf("too many IPs, at most %d allowed", h.bulk.MaxIPs)
+	body := http.MaxBytesReader(w, r.Body, h.bulk.maxBytes())
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl", "text/plain":
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, `"`) {
+				if err := json.Unmarshal([]byte(line), &line); err != nil {
+					return nil, http.StatusBadRequest, fmt.Errorf("invalid NDJSON line %d", len(ips)+1)
+				}
+			}
+			ips = append(ips, line)
+			if h.bulk.MaxIPs > 0 && len(ips) > h.bulk.MaxIPs {
+				return nil, http.StatusRequestEntityTooLarge, tooMany
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, bodyErrorStatus(err), fmt.Errorf("failed to read body: %w", err)
+		}
+	default:
+		invalid := errors.New("invalid JSON, want an array of IPs")
+		dec := json.NewDecoder(body)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, bodyErrorStatus(err), invalid
+		}
+		for dec.More() {
+			var ip string
+			if err := dec.Decode(&ip); err != nil {
+				return nil, bodyErrorStatus(err), invalid
+			}
+			ips = append(ips, ip)
+			if h.bulk.MaxIPs > 0 && len(ips) > h.bulk.MaxIPs {
+				return nil, http.StatusRequestEntityTooLarge, tooMany
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, bodyErrorStatus(err), invalid
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, http.StatusBadRequest, errors.New("no IPs given")
+	}
+	return ips, 0, nil
+}
+
+// bodyErrorStatus is 413 when reading the body failed on the size limit and
+// 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// lookupProblem maps a lookup failure to a problem that is safe to show to
+// clients.
+func lookupProblem(err error) *problem.Problem {
+	p := errorProblem(err)
+	if p.Status == http.StatusInternalServerError {
+		// Anything unexpected during a lookup comes from the provider.
+		return problem.New(http.StatusServiceUnavailable, "geolocation provider unavailable")
+	}
+	return p
+}
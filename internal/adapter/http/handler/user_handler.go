@@ -2,17 +2,23 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/mail"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 
+	"ip_detector/internal/adapter/http/middleware"
+	"ip_detector/internal/adapter/http/problem"
 	"ip_detector/internal/app/service"
 	"ip_detector/internal/domain/model"
-	"ip_detector/internal/logger"
 )
 
 type registerRequest struct {
@@ -22,84 +28,120 @@ type registerRequest struct {
 	Password string `json:"password" example:"secret123"`
 }
 
+type updateUserRequest struct {
+	Name  *string `json:"name"  validate:"omitnil,min=1" example:"John Doe"`
+	Email *string `json:"email" validate:"omitnil,email" example:"john@example.com"`
+	IP    *string `json:"ip"    validate:"omitnil,ip"    example:"8.8.8.8"`
+}
+
 type loginRequest struct {
 	Email    string `json:"email"    example:"john@example.com"`
 	Password string `json:"password" example:"secret123"`
 }
 
+// ClientIPMode decides where /register takes the user's IP from.
+type ClientIPMode string
+
+const (
+	// ClientIPFromBody trusts the "ip" field of the request body.
+	ClientIPFromBody ClientIPMode = "body"
+	// ClientIPDetect ignores the body and uses the detected client IP.
+	ClientIPDetect ClientIPMode = "detect"
+	// ClientIPCrossCheck uses the detected IP and rejects a body "ip" that
+	// does not match it.
+	ClientIPCrossCheck ClientIPMode = "crosscheck"
+)
+
+// ParseClientIPMode parses a mode name, case-insensitively.
+func ParseClientIPMode(s string) (ClientIPMode, error) {
+	switch m := ClientIPMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case ClientIPFromBody, ClientIPDetect, ClientIPCrossCheck:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported client IP mode %q, want body, detect or crosscheck", s)
+	}
+}
+
 type UserHandler struct {
 	service *service.UserService
+	tokens  *service.TokenService
+	ipMode  ClientIPMode
 }
 
-func NewUserHandler(service *service.UserService) *UserHandler {
-	return &UserHandler{service: service}
+func NewUserHandler(service *service.UserService, tokens *service.TokenService, ipMode ClientIPMode) *UserHandler {
+	return &UserHandler{service: service, tokens: tokens, ipMode: ipMode}
 }
 
 // ---------------- Register ----------------
 
 // RegisterUser godoc
 // @Summary      User Registration
-// @Description  Creates a new user, determines country by IP, hashes the password
+// @Description  Creates a new user, determines country by IP, hashes the password.
+// @Description  Depending on server configuration the IP is taken from the body or detected from the request.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload  body      registerRequest  true  "User Registration Data"
 // @Success      201      {object}  model.User
-// @Failure      400,500  {string}  string
+// @Failure      400,409,422,500,503  {object}  problem.Problem
 // @Router       /register [post]
 func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
-	log := logger.Log.Sugar()
+	log := middleware.Logger(r.Context())
 	log.Infow("register request received")
 
 	var input struct {
 		Name     string `json:"name" validate:"required"`
 		Email    string `json:"email" validate:"required,email"`
-		IP       string `json:"ip" validate:"required,ip"`
+		IP       string `json:"ip" validate:"omitempty,ip"`
 		Password string `json:"password" validate:"required,min=6"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		log.Warnw("invalid JSON", "error", err)
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
-	validate := validator.New()
 	if err := validate.Struct(input); err != nil {
 		log.Warnw("validation failed", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Validation(w, r, err)
 		return
 	}
 
-	if net.ParseIP(input.IP) == nil {
-		log.Warnw("invalid IP format", "ip", input.IP)
-		http.Error(w, "invalid IP address", http.StatusBadRequest)
+	ip, status, err := h.registrationIP(r, input.IP)
+	if err != nil {
+		log.Warnw("cannot determine registration IP", "ip", input.IP, "mode", h.ipMode, "error", err)
+		problem.Error(w, r, status, err.Error())
 		return
 	}
 
 	if _, err := mail.ParseAddress(input.Email); err != nil {
 		log.Warnw("invalid email format", "email", input.Email)
-		http.Error(w, "invalid email address", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid email address")
 		return
 	}
 
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Errorw("password hash error", "error", err)
-		http.Error(w, "failed to hash password", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, "failed to hash password")
 		return
 	}
 
 	user := model.User{
 		Name:         input.Name,
 		Email:        input.Email,
-		IP:           input.IP,
+		IP:           ip,
 		PasswordHash: string(passwordHash),
 	}
 
 	if err := h.service.CreateUser(r.Context(), &user); err != nil {
-		log.Errorw("create user failed", "email", user.Email, "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := writeError(w, r, err)
+		if status >= http.StatusInternalServerError {
+			log.Errorw("create user failed", "email", user.Email, "error", err)
+		} else {
+			log.Warnw("create user rejected", "email", user.Email, "ip", user.IP, "error", err)
+		}
 		return
 	}
 
@@ -108,20 +150,66 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(user)
 }
 
+// registrationIP picks the IP to register according to the handler's
+// ClientIPMode and returns the HTTP status to use when it cannot.
+func (h *UserHandler) registrationIP(r *http.Request, bodyIP string) (string, int, error) {
+	if h.ipMode == ClientIPDetect || h.ipMode == ClientIPCrossCheck {
+		detected, ok := middleware.ClientIPFromContext(r.Context())
+		if !ok {
+			return "", http.StatusBadRequest, errors.New("cannot determine client IP")
+		}
+		if h.ipMode == ClientIPCrossCheck && bodyIP != "" && !sameIP(bodyIP, detected) {
+			return "", http.StatusUnprocessableEntity, errors.New("ip does not match the request origin")
+		}
+		return detected, 0, nil
+	}
+
+	if bodyIP == "" {
+		return "", http.StatusBadRequest, errors.New("ip is required")
+	}
+	if net.ParseIP(bodyIP) == nil {
+		return "", http.StatusBadRequest, errors.New("invalid IP address")
+	}
+	return bodyIP, 0, nil
+}
+
+// updateIP checks a new IP sent to PATCH /users/{id} or /me. Outside
+// ClientIPFromBody mode it must be the detected client IP, as on
+// registration, so the mode cannot be bypassed by updating the IP later.
+func (h *UserHandler) updateIP(r *http.Request, bodyIP *string) (int, error) {
+	if bodyIP == nil || h.ipMode == ClientIPFromBody {
+		return 0, nil
+	}
+	detected, ok := middleware.ClientIPFromContext(r.Context())
+	if !ok {
+		return http.StatusBadRequest, errors.New("cannot determine client IP")
+	}
+	if !sameIP(*bodyIP, detected) {
+		return http.StatusUnprocessableEntity, errors.New("ip does not match the request origin")
+	}
+	return 0, nil
+}
+
+func sameIP(a, b string) bool {
+	x, errX := netip.ParseAddr(a)
+	y, errY := netip.ParseAddr(b)
+	return errX == nil && errY == nil && x.Unmap() == y.Unmap()
+}
+
 // ---------------- Login ----------------
 
 // Login godoc
 // @Summary      User Login
-// @Description  Verifies user credentials and returns a JWT
+// @Description  Verifies user credentials and returns a short-lived JWT with a refresh token
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload  body      loginRequest  true  "User Login Data"
-// @Success      200      {object}  map[string]string "token"
-// @Failure      400,401,500  {string}  string
+// @Success      200      {object}  model.TokenPair
+// @Failure      400,401,500  {object}  problem.Problem
 // @Router       /login [post]
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	log := logger.Log.Sugar()
+	log := middleware.Logger(r.Context())
 	log.Infow("login request received")
 
 	var credentials struct {
@@ -131,98 +219,196 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
 		log.Warnw("invalid JSON", "error", err)
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
-	validate := validator.New()
 	if err := validate.Struct(credentials); err != nil {
 		log.Warnw("validation failed", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Validation(w, r, err)
 		return
 	}
 
 	user, err := h.service.GetUserByEmail(r.Context(), credentials.Email)
-	if err != nil {
-		log.Errorw("DB error on login", "email", credentials.Email, "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Warnw("user not found", "email", credentials.Email)
+		problem.Error(w, r, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
-	if user == nil {
-		log.Warnw("user not found", "email", credentials.Email)
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+	if err != nil {
+		log.Errorw("DB error on login", "email", credentials.Email, "error", err)
+		writeError(w, r, err)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password)); err != nil {
 		log.Warnw("password mismatch", "email", credentials.Email)
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
 
-	token, err := h.service.GenerateJWT(user.Email)
+	clientIP, _ := middleware.ClientIPFromContext(r.Context())
+	h.service.RecordLogin(r.Context(), user, clientIP)
+
+	tokens, err := h.tokens.Issue(r.Context(), user)
 	if err != nil {
 		log.Errorw("token generation failed", "email", user.Email, "error", err)
-		http.Error(w, "failed to generate JWT", http.StatusInternalServerError)
+		problem.Error(w, r, http.StatusInternalServerError, "failed to generate JWT")
 		return
 	}
 
 	log.Infow("login successful", "email", user.Email)
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	_ = json.NewEncoder(w).Encode(tokens)
 }
 
 // ---------------- GetUsers ----------------
 
 // GetUsers godoc
 // @Summary      List Users
+// @Description  Lists users one page at a time. Pass next_cursor from the response as cursor to get the
+// @Description  following page, keeping the same sort. Filters combine with AND. Requires the admin role.
 // @Tags         users
 // @Security     BearerAuth
 // @Produce      json
-// @Success      200  {array}   model.User
-// @Failure      401,500  {string}  string
+// @Param        limit            query     int     false  "Page size (default 50, max 200)"
+// @Param        cursor           query     string  false  "next_cursor of the previous page"
+// @Param        sort             query     string  false  "created_at, name, email or country; prefix with - for descending"
+// @Param        country          query     string  false  "Country code or name"
+// @Param        email            query     string  false  "Email substring"
+// @Param        name             query     string  false  "Name substring"
+// @Param        cidr             query     string  false  "Subnet, e.g. 203.0.113.0/24"
+// @Param        registered_from  query     string  false  "RFC 3339 time or date, inclusive"
+// @Param        registered_to    query     string  false  "RFC 3339 time (exclusive) or date (inclusive)"
+// @Success      200  {object}  model.UserPage
+// @Failure      400,401,403,500  {object}  problem.Problem
 // @Router       /users [get]
 func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
-	log := logger.Log.Sugar()
-	log.Infow("get users request")
+	log := middleware.Logger(r.Context())
+	log.Infow("get users request", "query", r.URL.RawQuery)
 
-	users, err := h.service.GetAllUsers(r.Context())
+	q, err := parseUserQuery(r)
 	if err != nil {
-		log.Errorw("failed to fetch users", "error", err)
-		http.Error(w, "failed to fetch users", http.StatusInternalServerError)
+		log.Warnw("invalid users query", "error", err)
+		problem.Error(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	log.Infow("users fetched", "count", len(users))
+	page, err := h.service.QueryUsers(r.Context(), q)
+	if err != nil {
+		log.Warnw("failed to fetch users", "error", err)
+		writeError(w, r, err)
+		return
+	}
+
+	log.Infow("users fetched", "count", len(page.Users))
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(users)
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// parseUserQuery reads the GET /users query parameters.
+func parseUserQuery(r *http.Request) (model.UserQuery, error) {
+	params := r.URL.Query()
+	q := model.UserQuery{
+		Cursor:  params.Get("cursor"),
+		Country: params.Get("country"),
+		Email:   params.Get("email"),
+		Name:    params.Get("name"),
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+
+	if v := params.Get("sort"); v != "" {
+		field, desc := strings.CutPrefix(v, "-")
+		switch sort := model.UserSort(field); sort {
+		case model.SortByCreatedAt, model.SortByName, model.SortByEmail, model.SortByCountry:
+			q.Sort, q.Desc = sort, desc
+		default:
+			return q, fmt.Errorf("invalid sort field %q", field)
+		}
+	}
+
+	if v := params.Get("cidr"); v != "" {
+		prefix, err := parseCIDR(v)
+		if err != nil {
+			return q, errors.New("invalid CIDR")
+		}
+		q.CIDR = &prefix
+	}
+
+	if v := params.Get("registered_from"); v != "" {
+		t, _, err := parseTimeOrDate(v)
+		if err != nil {
+			return q, errors.New("invalid registered_from")
+		}
+		q.RegisteredAfter = &t
+	}
+	if v := params.Get("registered_to"); v != "" {
+		t, dateOnly, err := parseTimeOrDate(v)
+		if err != nil {
+			return q, errors.New("invalid registered_to")
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		q.RegisteredBefore = &t
+	}
+	return q, nil
+}
+
+func parseTimeOrDate(s string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse(time.DateOnly, s)
+	return t, true, err
+}
+
+// parseCIDR parses a subnet, unmapping IPv4-mapped IPv6 prefixes the way
+// stored IPs are normalized.
+func parseCIDR(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
 }
 
 // ---------------- GetUserByID ----------------
 
 // GetUserByID godoc
 // @Summary      Get User by ID
+// @Description  Users may only read themselves unless they are admins.
 // @Tags         users
 // @Security     BearerAuth
 // @Produce      json
 // @Param        id   path      string  true  "User ID"
 // @Success      200  {object}  model.User
-// @Failure      401,404,500  {string}  string
+// @Failure      400,401,403,404,500  {object}  problem.Problem
 // @Router       /users/{id} [get]
 func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	log := logger.Log.Sugar()
+	log := middleware.Logger(r.Context())
 	log.Infow("get user by id request", "id", id)
 
-	user, err := h.service.GetUserByID(r.Context(), id)
-	if err != nil {
-		log.Errorw("failed to fetch user", "id", id, "error", err)
-		http.Error(w, "failed to fetch user", http.StatusInternalServerError)
+	actor, ok := h.caller(w, r)
+	if !ok {
 		return
 	}
-	if user == nil {
-		log.Infow("user not found", "id", id)
-		http.Error(w, "user not found", http.StatusNotFound)
+
+	user, err := h.service.GetUserByID(r.Context(), actor, id)
+	if err != nil {
+		log.Warnw("failed to fetch user", "id", id, "error", err)
+		writeError(w, r, err)
 		return
 	}
 
@@ -230,3 +416,164 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(user)
 }
+
+// ---------------- UpdateUser ----------------
+
+// UpdateUser godoc
+// @Summary      Update User
+// @Description  Changes name, email or IP of a user. Fields left out stay unchanged; a new IP is geolocated again.
+// @Description  Unless CLIENT_IP_MODE is body, a new IP must be the address the request comes from.
+// @Description  Users may only update themselves unless they are admins.
+// @Tags         users
+// @Security     BearerAuth
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string             true  "User ID"
+// @Param        payload  body      updateUserRequest  true  "Fields to change"
+// @Success      200      {object}  model.User
+// @Failure      400,401,403,404,409,422,503  {object}  problem.Problem
+// @Router       /users/{id} [patch]
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, mux.Vars(r)["id"])
+}
+
+// update handles PATCH /users/{id} and PATCH /me. An empty id targets the
+// caller.
+func (h *UserHandler) update(w http.ResponseWriter, r *http.Request, id string) {
+	log := middleware.Logger(r.Context())
+	log.Infow("update user request", "id", id)
+
+	var input updateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		log.Warnw("invalid JSON", "error", err)
+		problem.Error(w, r, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if err := validate.Struct(input); err != nil {
+		log.Warnw("validation failed", "error", err)
+		problem.Validation(w, r, err)
+		return
+	}
+	if status, err := h.updateIP(r, input.IP); err != nil {
+		log.Warnw("update IP rejected", "ip", *input.IP, "mode", h.ipMode, "error", err)
+		problem.Error(w, r, status, err.Error())
+		return
+	}
+
+	actor, ok := h.caller(w, r)
+	if !ok {
+		return
+	}
+	if id == "" {
+		id = actor.ID
+	}
+
+	user, err := h.service.UpdateUser(r.Context(), actor, id, service.UserUpdate{
+		Name:  input.Name,
+		Email: input.Email,
+		IP:    input.IP,
+	})
+	if err != nil {
+		if status := writeError(w, r, err); status >= http.StatusInternalServerError {
+			log.Errorw("update user failed", "id", id, "error", err)
+		}
+		return
+	}
+
+	log.Infow("user updated", "id", id)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(user)
+}
+
+// ---------------- DeleteUser ----------------
+
+// DeleteUser godoc
+// @Summary      Delete User
+// @Description  Soft-deletes a user: the account disappears from all endpoints and its email can be registered again.
+// @Description  All tokens issued to the user are revoked.
+// @Description  Users may only delete themselves unless they are admins.
+// @Tags         users
+// @Security     BearerAuth
+// @Param        id   path      string  true  "User ID"
+// @Success      204
+// @Failure      400,401,403,404  {object}  problem.Problem
+// @Router       /users/{id} [delete]
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log := middleware.Logger(r.Context())
+	log.Infow("delete user request", "id", id)
+
+	actor, ok := h.caller(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteUser(r.Context(), actor, id); err != nil {
+		if status := writeError(w, r, err); status >= http.StatusInternalServerError {
+			log.Errorw("delete user failed", "id", id, "error", err)
+		}
+		return
+	}
+
+	log.Infow("user deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ---------------- Me ----------------
+
+// GetMe godoc
+// @Summary      Current User
+// @Description  Returns the caller's own profile, geolocation and last login.
+// @Tags         me
+// @Security     BearerAuth
+// @Produce      json
+// @Success      200  {object}  model.User
+// @Failure      401,500  {object}  problem.Problem
+// @Router       /me [get]
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.caller(w, r)
+	if !ok {
+		return
+	}
+
+	middleware.Logger(r.Context()).Infow("me fetched", "id", actor.ID)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(actor)
+}
+
+// UpdateMe godoc
+// @Summary      Update Current User
+// @Description  Changes the caller's name, email or IP. Fields left out stay unchanged; a new IP is geolocated again.
+// @Tags         me
+// @Security     BearerAuth
+// @Accept       json
+// @Produce      json
+// @Param        payload  body      updateUserRequest  true  "Fields to change"
+// @Success      200      {object}  model.User
+// @Failure      400,401,409,422,503  {object}  problem.Problem
+// @Router       /me [patch]
+func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "")
+}
+
+// caller loads the authenticated user by the token subject. A token whose
+// user no longer exists is answered with 401.
+func (h *UserHandler) caller(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, "not authenticated")
+		return nil, false
+	}
+
+	user, err := h.service.GetCurrentUser(r.Context(), principal.UserID)
+	if errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrInvalidID) {
+		problem.Error(w, r, http.StatusUnauthorized, "account no longer exists")
+		return nil, false
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Errorw("failed to load caller", "id", principal.UserID, "error", err)
+		writeError(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPKey contextKey = "client_ip"
+
+// ClientIPFromContext returns the IP resolved by ClientIPMiddleware.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok && ip != ""
+}
+
+// ClientIPHeader names the forwarding header the trusted proxies set.
+type ClientIPHeader string
+
+const (
+	// HeaderForwarded is the RFC 7239 Forwarded header.
+	HeaderForwarded ClientIPHeader = "forwarded"
+	// HeaderXForwardedFor is the X-Forwarded-For list.
+	HeaderXForwardedFor ClientIPHeader = "x-forwarded-for"
+	// HeaderXRealIP is the single address in X-Real-IP.
+	HeaderXRealIP ClientIPHeader = "x-real-ip"
+)
+
+// ParseClientIPHeader parses the name of a supported forwarding header,
+// case-insensitively.
+func ParseClientIPHeader(s string) (ClientIPHeader, error) {
+	switch h := ClientIPHeader(strings.ToLower(strings.TrimSpace(s))); h {
+	case HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP:
+		return h, nil
+	default:
+		return "", fmt.Errorf("unsupported client IP header %q, want forwarded, x-forwarded-for or x-real-ip", s)
+	}
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or bare IPs.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+			}
+			out = append(out, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
+
+// ClientIPMiddleware resolves the real client IP and stores it in the request
+// context. Only header, the one the trusted proxies set, is read, and only
+// when the direct peer is a trusted proxy; headers a client sends itself are
+// ignored. The forwarded chain is walked from the right, skipping trusted
+// hops, so a client cannot spoof its address by prepending entries.
+func ClientIPMiddleware(trusted []netip.Prefix, header ClientIPHeader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trusted, header)
+			if !ip.IsValid() {
+				Logger(r.Context()).Warnw("cannot determine client IP", "remote_addr", r.RemoteAddr)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), clientIPKey, ip.String())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func resolveClientIP(r *http.Request, trusted []netip.Prefix, header ClientIPHeader) netip.Addr {
+	remote := parseHostPort(r.RemoteAddr)
+	if !remote.IsValid() || !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	var hops []string
+	switch header {
+	case HeaderForwarded:
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case HeaderXForwardedFor:
+		for _, line := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(line, ",")...)
+		}
+	case HeaderXRealIP:
+		if h := r.Header.Get("X-Real-IP"); h != "" {
+			hops = []string{h}
+		}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHostPort(strings.TrimSpace(hops[i]))
+		if !hop.IsValid() {
+			// Unknown or obfuscated hop: nothing left of it can be trusted.
+			break
+		}
+		client = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor extracts the for= parameters from RFC 7239 Forwarded headers.
+func forwardedFor(headers []string) []string {
+	var out []string
+	for _, line := range headers {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					out = append(out, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// parseHostPort accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port".
+func parseHostPort(s string) netip.Addr {
+	if addr, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return addr.Unmap()
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xff, fwd, realIP := HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP
+	cases := []struct {
+		name    string
+		remote  string
+		header  ClientIPHeader
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "8.8.8.8:1234", xff, nil, "8.8.8.8"},
+		{"untrusted peer ignores headers", "8.8.8.8:1234", xff, map[string]string{"X-Forwarded-For": "1.1.1.1"}, "8.8.8.8"},
+		{"xff via trusted proxy", "10.0.0.2:80", xff, map[string]string{"X-Forwarded-For": "1.1.1.1"}, "1.1.1.1"},
+		{"xff spoofed prefix", "10.0.0.2:80", xff, map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1, 192.168.1.1"}, "1.1.1.1"},
+		{"xff ignores client forwarded", "10.0.0.2:80", xff, map[string]string{
+			"Forwarded":       "for=6.6.6.6",
+			"X-Forwarded-For": "1.1.1.1",
+		}, "1.1.1.1"},
+		{"x-real-ip", "192.168.1.1:80", realIP, map[string]string{"X-Real-IP": "9.9.9.9"}, "9.9.9.9"},
+		{"x-real-ip ignores xff", "192.168.1.1:80", realIP, map[string]string{"X-Forwarded-For": "6.6.6.6"}, "192.168.1.1"},
+		{"forwarded", "10.0.0.2:80", fwd, map[string]string{
+			"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`,
+			"X-Forwarded-For": "1.1.1.1",
+		}, "2001:db8:cafe::17"},
+		{"forwarded obfuscated", "10.0.0.2:80", fwd, map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.2"},
+		{"ipv6 peer", "[2001:4860::1]:443", xff, nil, "2001:4860::1"},
+		{"mapped ipv4 peer", "[::ffff:8.8.8.8]:443", xff, nil, "8.8.8.8"},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		if got := resolveClientIP(r, trusted, tc.header).String(); got != tc.want {
+			t.Errorf("%s: want %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseClientIPHeader(t *testing.T) {
+	if h, err := ParseClientIPHeader(" X-Forwarded-For "); err != nil || h != HeaderXForwardedFor {
+		t.Fatalf("want x-forwarded-for, got %q, %v", h, err)
+	}
+	if _, err := ParseClientIPHeader("x-client-ip"); err == nil {
+		t.Fatal("want error for unsupported header")
+	}
+}
@@ -3,45 +3,107 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"slices"
 	"strings"
 
+	"ip_detector/internal/adapter/http/problem"
 	"ip_detector/internal/auth"
-	"ip_detector/internal/logger"
+	"ip_detector/internal/domain/model"
 )
 
 type contextKey string
 
-const userEmailKey contextKey = "user_email"
+const principalKey contextKey = "principal"
 
-func JWTMiddleware(secret string) func(http.Handler) http.Handler {
+// Principal is the caller authenticated by JWTMiddleware, as claimed by its
+// token.
+type Principal struct {
+	// UserID is the token subject.
+	UserID string
+	// Role is the role claim; tokens without a known role count as
+	// model.RoleUser.
+	Role model.Role
+}
+
+// PrincipalFromContext returns the caller authenticated by JWTMiddleware.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok && p.UserID != ""
+}
+
+// TokenRevocations reports access tokens that were revoked before they
+// expired.
+type TokenRevocations interface {
+	IsRevoked(claims *auth.Claims) bool
+}
+
+// JWTMiddleware authenticates requests by a Bearer token signed by one of
+// keys. Tokens reported by revocations are rejected; revocations may be nil.
+func JWTMiddleware(keys *auth.KeySet, revocations TokenRevocations) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			log := logger.Log.Sugar()
+			log := Logger(r.Context())
 
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				log.Warn("authorization header missing")
-				http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+				unauthorized(w, r, "authorization header missing")
 				return
 			}
 
 			if !strings.HasPrefix(authHeader, "Bearer ") {
 				log.Warnw("authorization header without Bearer prefix", "header", authHeader)
-				http.Error(w, "Invalid token format", http.StatusUnauthorized)
+				unauthorized(w, r, "invalid token format, want Bearer <token>")
 				return
 			}
 
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-			email, err := auth.ParseToken(tokenStr, secret)
+			claims, err := auth.ParseToken(keys, tokenStr)
 			if err != nil {
 				log.Warnw("invalid token", "error", err)
-				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				unauthorized(w, r, "invalid or expired token")
 				return
 			}
 
-			log.Infow("token verified", "email", email)
-			ctx := context.WithValue(r.Context(), userEmailKey, email)
+			if revocations != nil && revocations.IsRevoked(claims) {
+				log.Warnw("revoked token", "user", claims.Subject, "jti", claims.ID)
+				unauthorized(w, r, "token has been revoked")
+				return
+			}
+
+			role := model.Role(claims.Role)
+			if !role.Valid() {
+				role = model.RoleUser
+			}
+
+			log.Infow("token verified", "user", claims.Subject, "role", role)
+			ctx := context.WithValue(r.Context(), principalKey, Principal{UserID: claims.Subject, Role: role})
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+func unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="ip_detector"`)
+	problem.Error(w, r, http.StatusUnauthorized, detail)
+}
+
+// RequireRole only lets callers through whose token claims one of roles.
+// It must run after JWTMiddleware.
+func RequireRole(roles ...model.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				unauthorized(w, r, "not authenticated")
+				return
+			}
+			if !slices.Contains(roles, p.Role) {
+				Logger(r.Context()).Warnw("role not allowed", "user", p.UserID, "role", p.Role, "path", r.URL.Path)
+				problem.Error(w, r, http.StatusForbidden, "insufficient role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"ip_detector/internal/adapter/http/problem"
+	"ip_detector/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+const requestIDKey contextKey = "request_id"
+
+// RequestIDMiddleware gives every request an ID, reusing a well-formed
+// X-Request-ID from the client. The ID is echoed in the response header
+// and available through RequestIDFromContext.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(problem.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(problem.RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the ID assigned by RequestIDMiddleware.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Logger returns the application logger with the request ID of ctx, so log
+// lines can be matched with the request_id of error responses.
+func Logger(ctx context.Context) *zap.SugaredLogger {
+	log := logger.Log.Sugar()
+	if id := RequestIDFromContext(ctx); id != "" {
+		log = log.With("request_id", id)
+	}
+	return log
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short IDs of visible ASCII characters, so client
+// input cannot inject anything into headers or logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
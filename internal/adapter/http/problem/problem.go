@@ -0,0 +1,103 @@
+// Package problem writes RFC 7807 application/problem+json error responses.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// RequestIDHeader carries the request ID. The request ID middleware sets it
+// on the response before any handler runs, so problems can include it.
+const RequestIDHeader = "X-Request-ID"
+
+// Problem types beyond the generic "about:blank".
+const (
+	TypeValidation  = "urn:ip-detector:problem:validation"
+	TypeRateLimited = "urn:ip-detector:problem:rate-limited"
+	TypeReservedIP  = "urn:ip-detector:problem:reserved-ip"
+	TypeConflict    = "urn:ip-detector:problem:conflict"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// New returns a generic problem with the standard title for status.
+func New(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// Write sends p, filling in the instance and request ID from r and w.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get(RequestIDHeader)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Error writes a generic problem; it replaces http.Error.
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	Write(w, r, New(status, detail))
+}
+
+// Validation writes a 400 problem listing the fields rejected by the
+// validator. Other errors are reported without field details.
+func Validation(w http.ResponseWriter, r *http.Request, err error) {
+	p := New(http.StatusBadRequest, "request validation failed")
+	p.Type = TypeValidation
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, fe := range verrs {
+			p.Errors = append(p.Errors, FieldError{Field: fe.Field(), Rule: fe.Tag(), Message: fieldMessage(fe)})
+		}
+	}
+	Write(w, r, p)
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "ip":
+		return "must be a valid IP address"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
@@ -2,25 +2,79 @@ package router
 
 import (
 	"net/http"
+	"net/netip"
+	"time"
 
 	"github.com/gorilla/mux"
 	"ip_detector/internal/adapter/http/handler"
 	"ip_detector/internal/adapter/http/middleware"
+	"ip_detector/internal/adapter/http/problem"
 	"ip_detector/internal/app/service"
+	"ip_detector/internal/auth"
+	"ip_detector/internal/domain/model"
 )
 
-func SetupRouter(userService *service.UserService, jwtSecret string) http.Handler {
+// Services are the application services the HTTP API is built on.
+type Services struct {
+	Users       *service.UserService
+	Tokens      *service.TokenService
+	Revocations *service.RevocationService
+	Lookup      *service.LookupService
+	Reenrich    *service.ReenrichService
+}
+
+type Config struct {
+	Keys           *auth.KeySet
+	TrustedProxies []netip.Prefix
+	ClientIPHeader middleware.ClientIPHeader
+	ClientIPMode   handler.ClientIPMode
+	BulkLookup     handler.BulkConfig
+
+	ReenrichInterval time.Duration
+}
+
+func SetupRouter(services *Services, cfg *Config) http.Handler {
 	r := mux.NewRouter()
+	r.Use(middleware.RequestIDMiddleware)
+	r.Use(middleware.ClientIPMiddleware(cfg.TrustedProxies, cfg.ClientIPHeader))
+	r.NotFoundHandler = middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusNotFound, "no such endpoint")
+	}))
+	r.MethodNotAllowedHandler = middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusMethodNotAllowed, "method not allowed for this endpoint")
+	}))
+
+	adminOnly := middleware.RequireRole(model.RoleAdmin)
 
-	userHandler := handler.NewUserHandler(userService)
+	userHandler := handler.NewUserHandler(services.Users, services.Tokens, cfg.ClientIPMode)
+	authHandler := handler.NewAuthHandler(services.Tokens, cfg.Keys)
+	lookupHandler := handler.NewLookupHandler(services.Lookup, cfg.BulkLookup)
+	adminHandler := handler.NewAdminHandler(services.Lookup, services.Reenrich, services.Revocations, cfg.ReenrichInterval)
 
 	r.HandleFunc("/register", userHandler.RegisterUser).Methods("POST")
 	r.HandleFunc("/login", userHandler.Login).Methods("POST")
+	r.HandleFunc("/token/refresh", authHandler.Refresh).Methods("POST")
+	r.HandleFunc("/logout", authHandler.Logout).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
+	r.HandleFunc("/lookup", lookupHandler.LookupSelf).Methods("GET")
+	r.HandleFunc("/lookup/{ip}", lookupHandler.LookupIP).Methods("GET")
 
 	protected := r.NewRoute().Subrouter()
-	protected.Use(middleware.JWTMiddleware(jwtSecret))
-	protected.HandleFunc("/users", userHandler.GetUsers).Methods("GET")
+	protected.Use(middleware.JWTMiddleware(cfg.Keys, services.Revocations))
+	protected.HandleFunc("/me", userHandler.GetMe).Methods("GET")
+	protected.HandleFunc("/me", userHandler.UpdateMe).Methods("PATCH")
+	protected.Handle("/users", adminOnly(http.HandlerFunc(userHandler.GetUsers))).Methods("GET")
 	protected.HandleFunc("/users/{id}", userHandler.GetUserByID).Methods("GET")
+	protected.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PATCH")
+	protected.HandleFunc("/users/{id}", userHandler.DeleteUser).Methods("DELETE")
+	protected.HandleFunc("/lookup/bulk", lookupHandler.BulkLookup).Methods("POST")
+
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(adminOnly)
+	admin.HandleFunc("/geoip", adminHandler.GeoIPDatabases).Methods("GET")
+	admin.HandleFunc("/geoip/cache", adminHandler.GeoIPCache).Methods("GET")
+	admin.HandleFunc("/reenrich", adminHandler.Reenrich).Methods("POST")
+	admin.HandleFunc("/users/{id}/revoke-sessions", adminHandler.RevokeSessions).Methods("POST")
 
 	return r
 }
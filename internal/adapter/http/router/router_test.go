@@ -2,62 +2,384 @@ package router_test
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"ip_detector/internal/logger"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"ip_detector/internal/adapter/external/geoip"
+	"ip_detector/internal/adapter/http/handler"
+	"ip_detector/internal/adapter/http/problem"
 	"ip_detector/internal/adapter/http/router"
 	"ip_detector/internal/app/service"
+	"ip_detector/internal/auth"
 	"ip_detector/internal/domain/model"
 	"ip_detector/internal/domain/port"
 )
 
 type mockRepo struct {
-	users map[string]*model.User
+	users   map[string]*model.User
+	deleted []*model.User
 }
 
 func newMockRepo() *mockRepo { return &mockRepo{users: map[string]*model.User{}} }
 
 func (m *mockRepo) Save(_ context.Context, u *model.User) error {
+	if _, ok := m.users[u.Email]; ok {
+		return model.ErrDuplicateEmail
+	}
+	if u.ID == "" {
+		u.ID = fmt.Sprintf("user-%d", len(m.users)+1)
+	}
+	u.CreatedAt = time.Now()
 	m.users[u.Email] = u
 	return nil
 }
+func (m *mockRepo) Update(_ context.Context, u *model.User) error {
+	for email, existing := range m.users {
+		if existing.ID == u.ID {
+			delete(m.users, email)
+			m.users[u.Email] = u
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+func (m *mockRepo) SetRole(_ context.Context, id string, role model.Role, _ time.Time) error {
+	for _, u := range m.users {
+		if u.ID == id {
+			u.Role = role
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+func (m *mockRepo) RecordLogin(_ context.Context, id, ip string, at time.Time) error {
+	for _, u := range m.users {
+		if u.ID == id {
+			u.LastLoginAt, u.LastLoginIP = &at, ip
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+func (m *mockRepo) SoftDelete(_ context.Context, id string, _ time.Time) error {
+	for email, u := range m.users {
+		if u.ID == id {
+			delete(m.users, email)
+			m.deleted = append(m.deleted, u)
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
 func (m *mockRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
-	return m.users[email], nil
+	if u, ok := m.users[email]; ok {
+		return u, nil
+	}
+	return nil, model.ErrNotFound
 }
-func (m *mockRepo) GetByID(_ context.Context, id string) (*model.User, error) { // not used
+func (m *mockRepo) GetByID(_ context.Context, id string) (*model.User, error) {
+	if strings.ContainsAny(id, " !") {
+		return nil, model.ErrInvalidID
+	}
 	for _, u := range m.users {
 		if u.ID == id {
 			return u, nil
 		}
 	}
-	return nil, nil
+	return nil, model.ErrNotFound
 }
-func (m *mockRepo) GetAll(_ context.Context) ([]*model.User, error) {
+
+// Query mimics the Postgres filters and ordering; its cursor is simply the
+// offset of the next page.
+func (m *mockRepo) Query(_ context.Context, q model.UserQuery) (*model.UserPage, error) {
 	var out []*model.User
 	for _, u := range m.users {
-		out = append(out, u)
+		addr, _ := netip.ParseAddr(u.IP)
+		switch {
+		case q.Country != "" && !strings.EqualFold(u.CountryCode, q.Country) && !strings.EqualFold(u.Country, q.Country):
+		case q.Email != "" && !strings.Contains(strings.ToLower(u.Email), strings.ToLower(q.Email)):
+		case q.Name != "" && !strings.Contains(strings.ToLower(u.Name), strings.ToLower(q.Name)):
+		case q.CIDR != nil && !q.CIDR.Contains(addr):
+		case q.RegisteredAfter != nil && u.CreatedAt.Before(*q.RegisteredAfter):
+		case q.RegisteredBefore != nil && !u.CreatedAt.Before(*q.RegisteredBefore):
+		default:
+			out = append(out, u)
+		}
+	}
+
+	key := func(u *model.User) string {
+		switch q.Sort {
+		case model.SortByName:
+			return u.Name
+		case model.SortByEmail:
+			return u.Email
+		case model.SortByCountry:
+			return u.CountryCode
+		}
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	}
+	slices.SortFunc(out, func(a, b *model.User) int {
+		c := cmp.Or(cmp.Compare(key(a), key(b)), cmp.Compare(a.ID, b.ID))
+		if q.Desc {
+			return -c
+		}
+		return c
+	})
+
+	offset := 0
+	if q.Cursor != "" {
+		n, err := strconv.Atoi(q.Cursor)
+		if err != nil || n < 0 || n > len(out) {
+			return nil, model.ErrInvalidCursor
+		}
+		offset = n
+	}
+	page := &model.UserPage{Users: out[offset:]}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.NextCursor = strconv.Itoa(offset + q.Limit)
+	}
+	return page, nil
+}
+func (m *mockRepo) FindForReenrichment(_ context.Context, sel model.ReenrichSelector) ([]*model.User, error) {
+	var out []*model.User
+	for _, u := range m.users {
+		if sel.EmptyCountry && u.Country == "" || slices.Contains(sel.IDs, u.ID) {
+			copied := *u
+			out = append(out, &copied)
+		}
 	}
 	return out, nil
 }
+func (m *mockRepo) UpdateGeoLocation(_ context.Context, id, ip string, loc *model.GeoLocation) error {
+	for _, u := range m.users {
+		if u.ID == id && u.IP == ip {
+			u.ApplyGeoLocation(loc)
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
 
 var _ port.UserRepository = (*mockRepo)(nil)
 
+type mockTokenStore struct {
+	tokens   []*model.RefreshToken
+	families int
+}
+
+func (m *mockTokenStore) Create(_ context.Context, t *model.RefreshToken) error {
+	if t.FamilyID == "" {
+		m.families++
+		t.FamilyID = fmt.Sprintf("family-%d", m.families)
+	}
+	t.ID = int64(len(m.tokens) + 1)
+	m.tokens = append(m.tokens, t)
+	return nil
+}
+func (m *mockTokenStore) GetByHash(_ context.Context, hash string) (*model.RefreshToken, error) {
+	for _, t := range m.tokens {
+		if t.TokenHash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, model.ErrNotFound
+}
+func (m *mockTokenStore) Rotate(ctx context.Context, old, next *model.RefreshToken) error {
+	stored := m.tokens[old.ID-1]
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		return model.ErrRefreshTokenReused
+	}
+	now := time.Now()
+	stored.UsedAt = &now
+	return m.Create(ctx, next)
+}
+func (m *mockTokenStore) RevokeFamily(_ context.Context, familyID string) error {
+	now := time.Now()
+	for _, t := range m.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenStore) RevokeUser(_ context.Context, userID string) error {
+	now := time.Now()
+	for _, t := range m.tokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// DeleteExpired keeps every token, since token IDs index m.tokens.
+func (m *mockTokenStore) DeleteExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+
+type mockRevocationStore struct {
+	repo       *mockRepo
+	revoked    map[string]time.Time
+	validAfter map[string]time.Time
+}
+
+func newMockRevocationStore(repo *mockRepo) *mockRevocationStore {
+	return &mockRevocationStore{repo: repo, revoked: map[string]time.Time{}, validAfter: map[string]time.Time{}}
+}
+
+func (m *mockRevocationStore) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	m.revoked[jti] = expiresAt
+	return nil
+}
+func (m *mockRevocationStore) ListRevoked(context.Context) (map[string]time.Time, error) {
+	return maps.Clone(m.revoked), nil
+}
+func (m *mockRevocationStore) DeleteExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+func (m *mockRevocationStore) SetTokensValidAfter(_ context.Context, userID string, t time.Time) error {
+	for _, u := range append(slices.Collect(maps.Values(m.repo.users)), m.repo.deleted...) {
+		if u.ID == userID {
+			m.validAfter[userID] = t
+			return nil
+		}
+	}
+	return model.ErrNotFound
+}
+func (m *mockRevocationStore) ListTokensValidAfter(_ context.Context, since time.Time) (map[string]time.Time, error) {
+	return maps.Clone(m.validAfter), nil
+}
+
 type geoIPMock struct{}
 
-func (g geoIPMock) GetCountryByIP(_ string) (string, error) { return "UA", nil }
+func (g geoIPMock) Lookup(_ context.Context, ip string) (*model.GeoLocation, error) {
+	return &model.GeoLocation{IP: ip, CountryCode: "UA", Country: "Ukraine", City: "Kyiv"}, nil
+}
+
+// batchGeoIPMock also resolves batches and records each one it is given.
+type batchGeoIPMock struct {
+	geoIPMock
+	batches [][]string
+}
+
+func (g *batchGeoIPMock) NativeBatch() bool { return true }
+
+func (g *batchGeoIPMock) LookupBatch(ctx context.Context, ips []string) ([]model.GeoLookupResult, error) {
+	g.batches = append(g.batches, ips)
+	results := make([]model.GeoLookupResult, len(ips))
+	for i, ip := range ips {
+		loc, err := g.Lookup(ctx, ip)
+		results[i] = model.GeoLookupResult{IP: ip, Location: loc, Err: err}
+	}
+	return results, nil
+}
+
+// concurrentGeoIP holds every lookup until want lookups are in flight, or
+// a second has passed, and records the highest number seen at once.
+type concurrentGeoIP struct {
+	want     int32
+	inflight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (g *concurrentGeoIP) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	n := g.inflight.Add(1)
+	defer g.inflight.Add(-1)
+	for {
+		if peak := g.peak.Load(); n <= peak || g.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for g.peak.Load() < g.want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return geoIPMock{}.Lookup(ctx, ip)
+}
+
+// movingGeoIP changes the IP of the looked up user before answering, like
+// a profile update that races a re-enrichment run.
+type movingGeoIP struct {
+	repo *mockRepo
+}
+
+func (g movingGeoIP) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	for _, u := range g.repo.users {
+		if u.IP == ip && u.Role != model.RoleAdmin {
+			u.IP = "9.9.9.9"
+		}
+	}
+	return geoIPMock{}.Lookup(ctx, ip)
+}
+
+type rateLimitedGeoIP struct{}
+
+func (rateLimitedGeoIP) Lookup(_ context.Context, _ string) (*model.GeoLocation, error) {
+	return nil, &model.RateLimitError{Provider: "ip-api", RetryAfter: 42 * time.Second}
+}
 
 func setupTestRouter() http.Handler {
+	return setupTestRouterWithGeo(geoIPMock{})
+}
+
+func setupTestRouterWithGeo(geo port.GeoIPService) http.Handler {
+	return setupTestRouterWithMode(geo, handler.ClientIPFromBody)
+}
+
+func setupTestRouterWithMode(geo port.GeoIPService, mode handler.ClientIPMode) http.Handler {
+	return setupTestRouterWithRepo(newMockRepo(), geo, mode)
+}
+
+func setupTestRouterWithRepo(repo *mockRepo, geo port.GeoIPService, mode handler.ClientIPMode) http.Handler {
+	return setupTestRouterWithKeys(repo, geo, mode, auth.NewHMACKeySet("supersecretkey"))
+}
+
+func setupTestRouterWithKeys(repo *mockRepo, geo port.GeoIPService, mode handler.ClientIPMode, keys *auth.KeySet) http.Handler {
 	logger.Init()
 
-	repo := newMockRepo()
-	geo := geoIPMock{}
-	cfg := &service.Config{JWTSecret: "supersecretkey", JWTExpiration: "24h"}
-	us := service.NewUserService(repo, geo, cfg)
-	return router.SetupRouter(us, "supersecretkey")
+	cfg := &service.Config{
+		Keys:          keys,
+		JWTExpiration: "15m",
+	}
+	refresh := &mockTokenStore{}
+	revocations := service.NewRevocationService(newMockRevocationStore(repo), refresh, cfg)
+	us := service.NewUserService(repo, geo, revocations, cfg)
+	ts := service.NewTokenService(repo, refresh, revocations, cfg)
+	ls := service.NewLookupService(geo)
+	rs := service.NewReenrichService(repo, geo)
+	return router.SetupRouter(&router.Services{
+		Users:       us,
+		Tokens:      ts,
+		Revocations: revocations,
+		Lookup:      ls,
+		Reenrich:    rs,
+	}, &router.Config{
+		Keys:         keys,
+		ClientIPMode: mode,
+		BulkLookup:   handler.BulkConfig{MaxIPs: 5, Concurrency: 3},
+	})
 }
 
 func TestRegisterOK(t *testing.T) {
@@ -72,6 +394,107 @@ func TestRegisterOK(t *testing.T) {
 	if rec.Code != http.StatusCreated {
 		t.Fatalf("want 201, got %d: %s", rec.Code, rec.Body.String())
 	}
+
+	var user model.User
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatalf("cannot parse user: %v", err)
+	}
+	if user.CountryCode != "UA" || user.City != "Kyiv" {
+		t.Fatalf("want geolocation in response, got %+v", user)
+	}
+}
+
+func TestRegisterRateLimited(t *testing.T) {
+	r := setupTestRouterWithGeo(rateLimitedGeoIP{})
+	rec := httptest.NewRecorder()
+
+	body := `{"name":"Alice","email":"alice@example.com","ip":"8.8.8.8","password":"secret123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "42" {
+		t.Fatalf("want Retry-After 42, got %q", got)
+	}
+}
+
+func TestRegisterReservedIPRejected(t *testing.T) {
+	r := setupTestRouter()
+
+	for _, ip := range []string{"127.0.0.1", "10.0.0.5", "::1", "192.0.2.1"} {
+		rec := httptest.NewRecorder()
+		body := `{"name":"Alice","email":"alice@example.com","ip":"` + ip + `","password":"secret123"}`
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: want 422, got %d: %s", ip, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRegisterDetectsClientIP(t *testing.T) {
+	r := setupTestRouterWithMode(geoIPMock{}, handler.ClientIPDetect)
+	rec := httptest.NewRecorder()
+
+	body := `{"name":"Alice","email":"alice@example.com","ip":"1.1.1.1","password":"secret123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	req.RemoteAddr = "8.8.8.8:5555"
+
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("want 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var user model.User
+	_ = json.Unmarshal(rec.Body.Bytes(), &user)
+	if user.IP != "8.8.8.8" {
+		t.Fatalf("want detected IP 8.8.8.8, got %q", user.IP)
+	}
+}
+
+func TestRegisterCrossCheckMismatch(t *testing.T) {
+	r := setupTestRouterWithMode(geoIPMock{}, handler.ClientIPCrossCheck)
+	rec := httptest.NewRecorder()
+
+	body := `{"name":"Alice","email":"alice@example.com","ip":"1.1.1.1","password":"secret123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	req.RemoteAddr = "8.8.8.8:5555"
+
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("want 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpdateIPFollowsClientIPMode(t *testing.T) {
+	r := setupTestRouterWithMode(geoIPMock{}, handler.ClientIPCrossCheck)
+
+	reg := httptest.NewRecorder()
+	body := `{"name":"Alice","email":"alice@example.com","password":"secret123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	req.RemoteAddr = "8.8.8.8:5555"
+	r.ServeHTTP(reg, req)
+	token := loginAs(t, r, "alice@example.com")
+
+	cases := map[string]int{
+		`{"ip":"1.1.1.1"}`: http.StatusUnprocessableEntity,
+		`{"ip":"8.8.4.4"}`: http.StatusOK,
+		`{"name":"Al"}`:    http.StatusOK,
+	}
+	for body, want := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPatch, "/me", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "8.8.4.4:5555"
+		r.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d: %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
 }
 
 func TestRegisterValidationFail(t *testing.T) {
@@ -122,10 +545,818 @@ func TestLoginAndProtected(t *testing.T) {
 	}
 
 	withTok := httptest.NewRecorder()
-	reqTok, _ := http.NewRequest(http.MethodGet, "/users", nil)
+	reqTok, _ := http.NewRequest(http.MethodGet, "/users/user-1", nil)
 	reqTok.Header.Set("Authorization", "Bearer "+resp.Token)
 	r.ServeHTTP(withTok, reqTok)
 	if withTok.Code != http.StatusOK {
 		t.Fatalf("want 200 with token, got %d", withTok.Code)
 	}
 }
+
+func TestLookup(t *testing.T) {
+	r := setupTestRouter()
+
+	cases := map[string]int{
+		"/lookup/8.8.8.8":         http.StatusOK,
+		"/lookup/2001:4860::8888": http.StatusOK,
+		"/lookup/not_ip":          http.StatusBadRequest,
+		"/lookup/10.0.0.1":        http.StatusUnprocessableEntity,
+	}
+	for path, want := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		r.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d: %s", path, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/lookup", nil)
+	req.RemoteAddr = "8.8.8.8:5555"
+	r.ServeHTTP(rec, req)
+
+	var loc model.GeoLocation
+	if err := json.Unmarshal(rec.Body.Bytes(), &loc); err != nil || loc.IP != "8.8.8.8" || loc.CountryCode != "UA" {
+		t.Fatalf("want own IP lookup, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLookupRateLimited(t *testing.T) {
+	r := setupTestRouterWithGeo(rateLimitedGeoIP{})
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/lookup/8.8.8.8", nil)
+
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "42" {
+		t.Fatalf("want 503 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func registerAndLogin(t *testing.T, r http.Handler, email string) string {
+	t.Helper()
+
+	reg := httptest.NewRecorder()
+	regBody := `{"name":"Test","email":"` + email + `","ip":"8.8.8.8","password":"secret123"}`
+	reqReg, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(regBody))
+	r.ServeHTTP(reg, reqReg)
+	if reg.Code != http.StatusCreated {
+		t.Fatalf("register failed: %d: %s", reg.Code, reg.Body.String())
+	}
+
+	return loginAs(t, r, email)
+}
+
+// registerAdmin registers a user, promotes it the way the set-role command
+// does and returns an admin token.
+func registerAdmin(t *testing.T, r http.Handler, repo *mockRepo, email string) string {
+	t.Helper()
+
+	registerAndLogin(t, r, email)
+	repo.users[email].Role = model.RoleAdmin
+	return loginAs(t, r, email)
+}
+
+func loginAs(t *testing.T, r http.Handler, email string) string {
+	t.Helper()
+
+	login := httptest.NewRecorder()
+	loginBody := `{"email":"` + email + `","password":"secret123"}`
+	reqLogin, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(loginBody))
+	r.ServeHTTP(login, reqLogin)
+
+	var resp struct{ Token string }
+	if err := json.Unmarshal(login.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("cannot parse token: %v, body: %s", err, login.Body.String())
+	}
+	return resp.Token
+}
+
+func TestBulkLookupNDJSON(t *testing.T) {
+	r := setupTestRouter()
+	token := registerAndLogin(t, r, "carol@example.com")
+
+	rec := httptest.NewRecorder()
+	body := "8.8.8.8\nnot_ip\n\"1.1.1.1\"\n10.0.0.1\n"
+	req, _ := http.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("want 200 NDJSON, got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	want := []struct {
+		ip     string
+		status int
+	}{
+		{"8.8.8.8", http.StatusOK},
+		{"not_ip", http.StatusBadRequest},
+		{"1.1.1.1", http.StatusOK},
+		{"10.0.0.1", http.StatusUnprocessableEntity},
+	}
+	dec := json.NewDecoder(rec.Body)
+	for _, w := range want {
+		var line struct {
+			IP       string
+			Status   int
+			Location *model.GeoLocation
+		}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("cannot decode line for %s: %v", w.ip, err)
+		}
+		if line.IP != w.ip || line.Status != w.status {
+			t.Fatalf("want %s/%d, got %s/%d", w.ip, w.status, line.IP, line.Status)
+		}
+		if w.status == http.StatusOK && line.Location == nil {
+			t.Fatalf("want location for %s", w.ip)
+		}
+	}
+}
+
+func TestBulkLookupUsesBatches(t *testing.T) {
+	geo := &batchGeoIPMock{}
+	r := setupTestRouterWithGeo(geo)
+	token := registerAndLogin(t, r, "erin@example.com")
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(`["8.8.8.8","10.0.0.1","1.1.1.1"]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(geo.batches) != 1 || !slices.Equal(geo.batches[0], []string{"8.8.8.8", "1.1.1.1"}) {
+		t.Fatalf("want one batch of the public IPs, got %v", geo.batches)
+	}
+
+	dec := json.NewDecoder(rec.Body)
+	for _, want := range []string{"8.8.8.8", "10.0.0.1", "1.1.1.1"} {
+		var line struct{ IP string }
+		if err := dec.Decode(&line); err != nil || line.IP != want {
+			t.Fatalf("want %s next, got %q (%v)", want, line.IP, err)
+		}
+	}
+}
+
+func TestBulkLookupRunsConcurrentlyBehindCache(t *testing.T) {
+	geo := &concurrentGeoIP{want: 3}
+	cache := geoip.NewCachedService(geo, geoip.CacheConfig{Size: 10, TTL: time.Minute})
+	r := setupTestRouterWithGeo(cache)
+	token := registerAndLogin(t, r, "frank@example.com")
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(`["8.8.4.4","1.1.1.1","9.9.9.9"]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if peak := geo.peak.Load(); peak != 3 {
+		t.Fatalf("want 3 lookups in flight at once, got %d", peak)
+	}
+}
+
+func TestBulkLookupLimits(t *testing.T) {
+	r := setupTestRouter()
+	token := registerAndLogin(t, r, "dave@example.com")
+
+	cases := map[string]int{
+		`["8.8.8.8","8.8.4.4","1.1.1.1","1.0.0.1","9.9.9.9","4.4.4.4"]`: http.StatusRequestEntityTooLarge,
+		`[]`:          http.StatusBadRequest,
+		`{"ip":"x"}`:  http.StatusBadRequest,
+		`["8.8.8.8"]`: http.StatusOK,
+		`[8]`:         http.StatusBadRequest,
+		`["8.8.8.8",` + strings.Repeat(" ", 1<<20) + `]`: http.StatusRequestEntityTooLarge,
+	}
+	for body, want := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%.40s: want %d, got %d", body, want, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(`["8.8.8.8"]`))
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401 without token, got %d", rec.Code)
+	}
+}
+
+func TestReenrich(t *testing.T) {
+	repo := newMockRepo()
+	repo.users["stale@example.com"] = &model.User{ID: "u1", Email: "stale@example.com", IP: "8.8.8.8"}
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	token := registerAdmin(t, r, repo, "admin@example.com")
+
+	run := func(body string) model.ReenrichReport {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/admin/reenrich", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+		var report model.ReenrichReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("cannot parse report: %v", err)
+		}
+		return report
+	}
+
+	report := run(`{"empty_country":true}`)
+	if !report.DryRun || report.Changed != 1 || report.Changes[0].NewCountryCode != "UA" {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if repo.users["stale@example.com"].Country != "" {
+		t.Fatal("dry run must not write")
+	}
+
+	report = run(`{"ids":["u1"],"apply":true}`)
+	if report.DryRun || report.Changed != 1 || repo.users["stale@example.com"].CountryCode != "UA" {
+		t.Fatalf("apply must update the user: %+v", report)
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/admin/reenrich", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 without selection, got %d", rec.Code)
+	}
+}
+
+func TestReenrichSkipsChangedIP(t *testing.T) {
+	repo := newMockRepo()
+	r := setupTestRouterWithRepo(repo, movingGeoIP{repo: repo}, handler.ClientIPFromBody)
+	token := registerAdmin(t, r, repo, "admin@example.com")
+	repo.users["moved@example.com"] = &model.User{ID: "u1", Email: "moved@example.com", IP: "8.8.4.4"}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/admin/reenrich", bytes.NewBufferString(`{"ids":["u1"],"apply":true}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+
+	var report model.ReenrichReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("want report, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if report.Skipped != 1 || report.Failed != 0 || report.Changed != 0 {
+		t.Fatalf("want the moved user skipped, got %+v", report)
+	}
+	if u := repo.users["moved@example.com"]; u.CountryCode != "" {
+		t.Fatalf("want no location written for the old IP, got %+v", u)
+	}
+}
+
+func TestUsersCIDRFilter(t *testing.T) {
+	repo := newMockRepo()
+	repo.users["a@example.com"] = &model.User{ID: "a", Email: "a@example.com", IP: "203.0.113.7"}
+	repo.users["b@example.com"] = &model.User{ID: "b", Email: "b@example.com", IP: "198.51.100.1"}
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	token := registerAdmin(t, r, repo, "admin@example.com")
+
+	cases := map[string]int{
+		"203.0.113.0/24":         1,
+		"::ffff:203.0.113.0/120": 1,
+		"8.8.8.0/24":             1,
+		"0.0.0.0/0":              3,
+		"2001:db8::/32":          0,
+	}
+	for cidr, want := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/users?cidr="+cidr, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+
+		var page model.UserPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || len(page.Users) != want {
+			t.Errorf("%s: want %d users, got %d: %s", cidr, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users?cidr=203.0.113.0/99", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 for invalid CIDR, got %d", rec.Code)
+	}
+}
+
+func TestUsersPagination(t *testing.T) {
+	repo := newMockRepo()
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Eve", "Dan", "Cid", "Bob", "Ann"} {
+		email := strings.ToLower(name) + "@example.com"
+		repo.users[email] = &model.User{
+			ID: strconv.Itoa(i), Name: name, Email: email, IP: "8.8.8.8",
+			CountryCode: []string{"UA", "PL"}[i%2], CreatedAt: base.AddDate(0, 0, i),
+		}
+	}
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	token := registerAdmin(t, r, repo, "admin@example.com")
+
+	get := func(query string) (int, model.UserPage) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/users?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		var page model.UserPage
+		_ = json.Unmarshal(rec.Body.Bytes(), &page)
+		return rec.Code, page
+	}
+
+	var names []string
+	query := "limit=2&sort=name&email=example.com&registered_to=2025-03-05"
+	for {
+		code, page := get(query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d", query, code)
+		}
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "limit=2&sort=name&email=example.com&registered_to=2025-03-05&cursor=" + page.NextCursor
+	}
+	if strings.Join(names, ",") != "Ann,Bob,Cid,Dan,Eve" {
+		t.Fatalf("unexpected pages: %v", names)
+	}
+
+	if _, page := get("country=pl&sort=-created_at"); len(page.Users) != 2 || page.Users[0].Name != "Bob" {
+		t.Fatalf("want PL users newest first, got %+v", page.Users)
+	}
+	if _, page := get("registered_from=2025-03-04&name=a"); len(page.Users) != 1 || page.Users[0].Name != "Ann" {
+		t.Fatalf("want date and name filter, got %+v", page.Users)
+	}
+
+	for _, bad := range []string{"limit=0", "sort=password", "registered_from=yesterday", "cursor=x"} {
+		if code, _ := get(bad); code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", bad, code)
+		}
+	}
+}
+
+func TestRepositoryErrorMapping(t *testing.T) {
+	repo := newMockRepo()
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	token := registerAdmin(t, r, repo, "admin@example.com")
+
+	rec := httptest.NewRecorder()
+	body := `{"name":"Gina","email":"admin@example.com","ip":"8.8.8.8","password":"secret123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("want 409 for duplicate email, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	cases := map[string]int{
+		"/users/00000000-0000-0000-0000-000000000000": http.StatusNotFound,
+		"/users/not%20a%20uuid":                       http.StatusBadRequest,
+	}
+	for path, want := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d: %s", path, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	r := setupTestRouter()
+
+	rec := httptest.NewRecorder()
+	body := `{"name":"","email":"not-an-email","ip":"8.8.8.8","password":"123"}`
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+	req.Header.Set("X-Request-ID", "req-42")
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("want 400 problem, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("cannot parse problem: %v", err)
+	}
+	if p.Type != problem.TypeValidation || p.Instance != "/register" || p.RequestID != "req-42" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+	var fields []string
+	for _, fe := range p.Errors {
+		fields = append(fields, fe.Field+":"+fe.Rule)
+	}
+	if strings.Join(fields, ",") != "name:required,email:email,password:min" {
+		t.Fatalf("unexpected field errors: %+v", p.Errors)
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/users", nil)
+	r.ServeHTTP(rec, req)
+	p = problem.Problem{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &p)
+	if rec.Code != http.StatusUnauthorized || p.Status != http.StatusUnauthorized || p.RequestID == "" ||
+		p.RequestID != rec.Header().Get("X-Request-ID") {
+		t.Fatalf("want 401 problem with generated request ID, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/nope", nil)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != problem.ContentType {
+		t.Fatalf("want 404 problem for unknown route, got %d", rec.Code)
+	}
+}
+
+func TestUpdateAndDeleteUser(t *testing.T) {
+	repo := newMockRepo()
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	owner := registerAndLogin(t, r, "hank@example.com")
+	other := registerAndLogin(t, r, "ivy@example.com")
+	admin := registerAdmin(t, r, repo, "admin@example.com")
+	id := repo.users["hank@example.com"].ID
+
+	send := func(method, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/users/"+id, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodPatch, other, `{"name":"Mallory"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("want 403 for another user, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPatch, owner, `{"email":"bad"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 for invalid email, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPatch, owner, `{"email":"ivy@example.com"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("want 409 for taken email, got %d", rec.Code)
+	}
+
+	repo.users["hank@example.com"].Country = "stale"
+	rec := send(http.MethodPatch, owner, `{"name":"Hank Hill","ip":"1.1.1.1"}`)
+	var user model.User
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if user.Name != "Hank Hill" || user.IP != "1.1.1.1" || user.Country != "Ukraine" || user.Email != "hank@example.com" {
+		t.Fatalf("want renamed and re-geolocated user, got %+v", user)
+	}
+
+	if rec := send(http.MethodPatch, admin, `{"name":"By Admin"}`); rec.Code != http.StatusOK {
+		t.Fatalf("want admin to update any user, got %d", rec.Code)
+	}
+
+	// Tokens name the user by ID, so they survive an email change and never
+	// reach whoever registers the old email next.
+	if rec := send(http.MethodPatch, owner, `{"email":"hank.hill@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("want 200 for email change, got %d: %s", rec.Code, rec.Body.String())
+	}
+	registerAndLogin(t, r, "hank@example.com")
+	rec = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+owner)
+	r.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil || user.ID != id || user.Email != "hank.hill@example.com" {
+		t.Fatalf("want old token to stay with its user, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := send(http.MethodDelete, other, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("want 403 deleting another user, got %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, owner, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, admin, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 after delete, got %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, owner, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401 for a deleted account's token, got %d", rec.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	repo := newMockRepo()
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	user := registerAndLogin(t, r, "jill@example.com")
+	admin := registerAdmin(t, r, repo, "admin@example.com")
+	userID := repo.users["jill@example.com"].ID
+	adminID := repo.users["admin@example.com"].ID
+
+	if repo.users["jill@example.com"].Role != model.RoleUser {
+		t.Fatalf("want users to register with the user role, got %q", repo.users["jill@example.com"].Role)
+	}
+
+	cases := []struct {
+		token, method, path string
+		want                int
+	}{
+		{user, http.MethodGet, "/users", http.StatusForbidden},
+		{user, http.MethodGet, "/users/" + userID, http.StatusOK},
+		{user, http.MethodGet, "/users/" + adminID, http.StatusForbidden},
+		{user, http.MethodGet, "/admin/geoip", http.StatusForbidden},
+		{user, http.MethodPost, "/admin/reenrich", http.StatusForbidden},
+		{admin, http.MethodGet, "/users", http.StatusOK},
+		{admin, http.MethodGet, "/users/" + userID, http.StatusOK},
+		{admin, http.MethodGet, "/admin/geoip", http.StatusOK},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(c.method, c.path, bytes.NewBufferString(`{}`))
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		r.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s: want %d, got %d: %s", c.method, c.path, c.want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestGeoIPCacheStats(t *testing.T) {
+	repo := newMockRepo()
+	cache := geoip.NewCachedService(geoIPMock{}, geoip.CacheConfig{Size: 10, TTL: time.Minute})
+	r := setupTestRouterWithRepo(repo, cache, handler.ClientIPFromBody)
+	admin := registerAdmin(t, r, repo, "admin@example.com")
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/lookup/8.8.8.8", nil)
+		r.ServeHTTP(rec, req)
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/geoip/cache", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	r.ServeHTTP(rec, req)
+
+	var stats model.GeoIPCacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("want stats, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Registering the admin looked up the same IP first.
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	r = setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/admin/geoip/cache", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 without a cache, got %d", rec.Code)
+	}
+}
+
+func TestMe(t *testing.T) {
+	r := setupTestRouter()
+	registerAndLogin(t, r, "kim@example.com")
+
+	login := httptest.NewRecorder()
+	reqLogin, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"email":"kim@example.com","password":"secret123"}`))
+	reqLogin.RemoteAddr = "8.8.4.4:5555"
+	r.ServeHTTP(login, reqLogin)
+	var resp struct{ Token string }
+	if err := json.Unmarshal(login.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("cannot parse token: %v, body: %s", err, login.Body.String())
+	}
+
+	send := func(method, token, body string) (int, model.User) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/me", bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(rec, req)
+		var user model.User
+		_ = json.Unmarshal(rec.Body.Bytes(), &user)
+		return rec.Code, user
+	}
+
+	code, me := send(http.MethodGet, resp.Token, "")
+	if code != http.StatusOK || me.Email != "kim@example.com" || me.CountryCode != "UA" || me.Role != model.RoleUser {
+		t.Fatalf("want own profile, got %d: %+v", code, me)
+	}
+	if me.LastLoginAt == nil || me.LastLoginIP != "8.8.4.4" {
+		t.Fatalf("want last login data, got %v %q", me.LastLoginAt, me.LastLoginIP)
+	}
+
+	if code, me = send(http.MethodPatch, resp.Token, `{"name":"Kim","ip":"1.1.1.1"}`); code != http.StatusOK || me.Name != "Kim" || me.IP != "1.1.1.1" {
+		t.Fatalf("want updated profile, got %d: %+v", code, me)
+	}
+	if code, _ = send(http.MethodPatch, resp.Token, `{"ip":"nope"}`); code != http.StatusBadRequest {
+		t.Fatalf("want 400 for invalid IP, got %d", code)
+	}
+	if code, _ = send(http.MethodGet, "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("want 401 without token, got %d", code)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	r := setupTestRouter()
+	registerAndLogin(t, r, "leo@example.com")
+
+	post := func(path, body string) (int, model.TokenPair) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		r.ServeHTTP(rec, req)
+		var pair model.TokenPair
+		_ = json.Unmarshal(rec.Body.Bytes(), &pair)
+		return rec.Code, pair
+	}
+	refresh := func(token string) (int, model.TokenPair) {
+		t.Helper()
+		return post("/token/refresh", `{"refresh_token":"`+token+`"}`)
+	}
+
+	code, first := post("/login", `{"email":"leo@example.com","password":"secret123"}`)
+	if code != http.StatusOK || first.Token == "" || first.RefreshToken == "" || first.ExpiresIn != 900 || first.TokenType != "Bearer" {
+		t.Fatalf("want token pair from login, got %d: %+v", code, first)
+	}
+
+	code, second := refresh(first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("want rotated refresh token, got %d: %+v", code, second)
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+second.Token)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want refreshed access token to work, got %d", rec.Code)
+	}
+
+	if code, _ := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("want 401 when replaying a rotated token, got %d", code)
+	}
+	if code, _ := refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("want reuse to revoke the whole family, got %d", code)
+	}
+
+	_, other := post("/login", `{"email":"leo@example.com","password":"secret123"}`)
+	_, third := post("/login", `{"email":"leo@example.com","password":"secret123"}`)
+	if code, _ := post("/logout", `{"refresh_token":"`+other.RefreshToken+`"}`); code != http.StatusNoContent {
+		t.Fatalf("want 204 from logout, got %d", code)
+	}
+	if code, _ := refresh(other.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("want 401 after logout, got %d", code)
+	}
+	if code, _ := refresh(third.RefreshToken); code != http.StatusOK {
+		t.Fatalf("want other sessions to survive logout, got %d", code)
+	}
+
+	if code, _ := post("/token/refresh", `{}`); code != http.StatusBadRequest {
+		t.Fatalf("want 400 without refresh token, got %d", code)
+	}
+	if code, _ := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("want 401 for unknown refresh token, got %d", code)
+	}
+}
+
+func TestTokenRevocation(t *testing.T) {
+	repo := newMockRepo()
+	r := setupTestRouterWithRepo(repo, geoIPMock{}, handler.ClientIPFromBody)
+	admin := registerAdmin(t, r, repo, "admin@example.com")
+	registerAndLogin(t, r, "mia@example.com")
+	id := repo.users["mia@example.com"].ID
+
+	login := func() model.TokenPair {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"email":"mia@example.com","password":"secret123"}`))
+		r.ServeHTTP(rec, req)
+		var pair model.TokenPair
+		if err := json.Unmarshal(rec.Body.Bytes(), &pair); err != nil || pair.Token == "" {
+			t.Fatalf("login failed: %d: %s", rec.Code, rec.Body.String())
+		}
+		return pair
+	}
+	send := func(method, path, token, body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	first, second := login(), login()
+	if code := send(http.MethodPost, "/logout", first.Token, `{"refresh_token":"`+first.RefreshToken+`"}`); code != http.StatusNoContent {
+		t.Fatalf("want 204 from logout, got %d", code)
+	}
+	if code := send(http.MethodGet, "/me", first.Token, ""); code != http.StatusUnauthorized {
+		t.Fatalf("want logged out access token to be rejected, got %d", code)
+	}
+	if code := send(http.MethodGet, "/me", second.Token, ""); code != http.StatusOK {
+		t.Fatalf("want other token to stay valid, got %d", code)
+	}
+
+	if code := send(http.MethodPost, "/admin/users/"+id+"/revoke-sessions", second.Token, ""); code != http.StatusForbidden {
+		t.Fatalf("want 403 for non-admin, got %d", code)
+	}
+	if code := send(http.MethodPost, "/admin/users/00000000-0000-0000-0000-000000000000/revoke-sessions", admin, ""); code != http.StatusNotFound {
+		t.Fatalf("want 404 for unknown user, got %d", code)
+	}
+	if code := send(http.MethodPost, "/admin/users/"+id+"/revoke-sessions", admin, ""); code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d", code)
+	}
+	if code := send(http.MethodGet, "/me", second.Token, ""); code != http.StatusUnauthorized {
+		t.Fatalf("want access token issued before revocation to be rejected, got %d", code)
+	}
+	if code := send(http.MethodPost, "/token/refresh", "", `{"refresh_token":"`+second.RefreshToken+`"}`); code != http.StatusUnauthorized {
+		t.Fatalf("want refresh tokens to be revoked, got %d", code)
+	}
+
+	// iat has whole-second precision: tokens from the revocation's second are rejected too.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	third := login()
+	if code := send(http.MethodGet, "/me", third.Token, ""); code != http.StatusOK {
+		t.Fatalf("want new login to work, got %d", code)
+	}
+
+	if code := send(http.MethodDelete, "/users/"+id, third.Token, ""); code != http.StatusNoContent {
+		t.Fatalf("want 204 from delete, got %d", code)
+	}
+	if code := send(http.MethodPost, "/lookup/bulk", third.Token, `["8.8.8.8"]`); code != http.StatusUnauthorized {
+		t.Fatalf("want deleted user's token to be rejected, got %d", code)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	jwks := func(r http.Handler) auth.JWKS {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") == "" {
+			t.Fatalf("want cacheable 200, got %d %v", rec.Code, rec.Header())
+		}
+		var set auth.JWKS
+		if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+
+	if set := jwks(setupTestRouter()); len(set.Keys) != 0 {
+		t.Fatalf("want HMAC secret to stay private, got %+v", set.Keys)
+	}
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeySet("", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := setupTestRouterWithKeys(newMockRepo(), geoIPMock{}, handler.ClientIPFromBody, keys)
+	set := jwks(r)
+	if len(set.Keys) != 1 || set.Keys[0].Kid != keys.SigningKeyID() || set.Keys[0].Alg != "EdDSA" {
+		t.Fatalf("want the signing key, got %+v", set.Keys)
+	}
+
+	token := registerAndLogin(t, r, "kim@example.com")
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want EdDSA token to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	hmacToken := registerAndLogin(t, setupTestRouter(), "kim@example.com")
+	rec = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer "+hmacToken)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want HMAC token to be rejected once keys are configured, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// EnrichmentWorkerConfig tunes the EnrichmentWorker. Zero values fall back
+// to the defaults below.
+type EnrichmentWorkerConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+}
+
+// EnrichmentWorker resolves the location of users registered in
+// EnrichmentAsync mode. Failed lookups are retried with exponential
+// backoff; unknown IPs and jobs out of attempts mark the user as failed.
+type EnrichmentWorker struct {
+	queue port.EnrichmentQueue
+	geoIP port.GeoIPService
+	cfg   EnrichmentWorkerConfig
+}
+
+func NewEnrichmentWorker(queue port.EnrichmentQueue, geoIP port.GeoIPService, cfg EnrichmentWorkerConfig) *EnrichmentWorker {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 5 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = cfg.Backoff
+	}
+	return &EnrichmentWorker{queue: queue, geoIP: geoIP, cfg: cfg}
+}
+
+// Run processes jobs with cfg.Workers goroutines until ctx is done.
+func (w *EnrichmentWorker) Run(ctx context.Context) {
+	logger.Log.Sugar().Infow("enrichment worker started", "workers", w.cfg.Workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *EnrichmentWorker) loop(ctx context.Context) {
+	log := logger.Log.Sugar()
+
+	for ctx.Err() == nil {
+		jobs, err := w.queue.Claim(ctx, 1, w.cfg.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Errorw("claim enrichment job failed", "error", err)
+		}
+		if len(jobs) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.cfg.PollInterval):
+			}
+			continue
+		}
+		for _, job := range jobs {
+			w.process(ctx, job)
+		}
+	}
+}
+
+func (w *EnrichmentWorker) process(ctx context.Context, job *model.EnrichmentJob) {
+	log := logger.Log.Sugar()
+
+	loc, err := w.geoIP.Lookup(ctx, job.IP)
+	if err == nil {
+		if err := w.queue.Complete(ctx, job, loc); err != nil {
+			log.Errorw("complete enrichment job failed", "job", job.ID, "error", err)
+			return
+		}
+		log.Infow("user enriched", "id", job.UserID, "country", loc.CountryCode, "attempts", job.Attempts)
+		return
+	}
+
+	// Shutting down: hand the job back without waiting for its lease.
+	if ctx.Err() != nil {
+		_ = w.queue.Retry(context.WithoutCancel(ctx), job, time.Now(), "interrupted")
+		return
+	}
+
+	if errors.Is(err, model.ErrLocationNotFound) || job.Attempts >= w.cfg.MaxAttempts {
+		log.Warnw("enrichment failed", "id", job.UserID, "ip", job.IP, "attempts", job.Attempts, "error", err)
+		if err := w.queue.Fail(ctx, job, err.Error()); err != nil {
+			log.Errorw("fail enrichment job failed", "job", job.ID, "error", err)
+		}
+		return
+	}
+
+	delay := w.backoff(job.Attempts)
+	var rateLimited *model.RateLimitError
+	if errors.As(err, &rateLimited) && rateLimited.RetryAfter > delay {
+		delay = rateLimited.RetryAfter
+	}
+	log.Warnw("enrichment attempt failed, retrying", "id", job.UserID, "attempts", job.Attempts, "retry_in", delay, "error", err)
+	if err := w.queue.Retry(ctx, job, time.Now().Add(delay), err.Error()); err != nil {
+		log.Errorw("reschedule enrichment job failed", "job", job.ID, "error", err)
+	}
+}
+
+// backoff doubles cfg.Backoff with every attempt up to cfg.MaxBackoff.
+func (w *EnrichmentWorker) backoff(attempt int) time.Duration {
+	d := w.cfg.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= w.cfg.MaxBackoff {
+			return w.cfg.MaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,226 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+type memQueue struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*queuedJob
+	users  map[string]*model.User
+	done   chan string
+}
+
+type queuedJob struct {
+	job    model.EnrichmentJob
+	runAt  time.Time
+	locked bool
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{jobs: map[int64]*queuedJob{}, users: map[string]*model.User{}, done: make(chan string, 10)}
+}
+
+// enqueue adds a due job; the caller holds q.mu.
+func (q *memQueue) enqueue(job model.EnrichmentJob) {
+	q.nextID++
+	job.ID = q.nextID
+	q.jobs[job.ID] = &queuedJob{job: job, runAt: time.Now()}
+}
+
+func (q *memQueue) Claim(_ context.Context, limit int, _ time.Duration) ([]*model.EnrichmentJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []*model.EnrichmentJob
+	for _, j := range q.jobs {
+		if len(out) == limit {
+			break
+		}
+		if j.locked || j.runAt.After(time.Now()) {
+			continue
+		}
+		j.locked = true
+		j.job.Attempts++
+		job := j.job
+		out = append(out, &job)
+	}
+	return out, nil
+}
+
+func (q *memQueue) Complete(_ context.Context, job *model.EnrichmentJob, loc *model.GeoLocation) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u := q.users[job.UserID]; u.IP == job.IP {
+		u.ApplyGeoLocation(loc)
+		u.EnrichmentStatus = model.EnrichmentDone
+	}
+	delete(q.jobs, job.ID)
+	q.done <- job.UserID
+	return nil
+}
+
+func (q *memQueue) Retry(_ context.Context, job *model.EnrichmentJob, runAt time.Time, _ string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[job.ID].locked = false
+	q.jobs[job.ID].runAt = runAt
+	return nil
+}
+
+func (q *memQueue) Fail(_ context.Context, job *model.EnrichmentJob, _ string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u := q.users[job.UserID]; u.IP == job.IP {
+		u.EnrichmentStatus = model.EnrichmentFailed
+	}
+	delete(q.jobs, job.ID)
+	q.done <- job.UserID
+	return nil
+}
+
+func (q *memQueue) status(id string) model.EnrichmentStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.users[id].EnrichmentStatus
+}
+
+// memRepo saves users into the queue's user map, like the shared users
+// table in Postgres, and queues pending ones in the same step. Other
+// repository methods are not used.
+type memRepo struct {
+	port.UserRepository
+	q *memQueue
+}
+
+func (r memRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	if u, ok := r.q.users[email]; ok {
+		return u, nil
+	}
+	return nil, model.ErrNotFound
+}
+
+func (r memRepo) Save(_ context.Context, u *model.User) error {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	u.ID = u.Email
+	r.q.users[u.ID] = u
+	if u.EnrichmentStatus == model.EnrichmentPending {
+		r.q.enqueue(model.EnrichmentJob{UserID: u.ID, IP: u.IP})
+	}
+	return nil
+}
+
+// flakyGeoIP fails the first failures lookups of every IP.
+type flakyGeoIP struct {
+	mu       sync.Mutex
+	failures int
+	calls    map[string]int
+}
+
+func (g *flakyGeoIP) Lookup(_ context.Context, ip string) (*model.GeoLocation, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls[ip]++
+	if ip == "1.2.3.4" {
+		return nil, model.ErrLocationNotFound
+	}
+	if g.calls[ip] <= g.failures {
+		return nil, errors.New("provider unavailable")
+	}
+	return &model.GeoLocation{IP: ip, CountryCode: "UA", Country: "Ukraine"}, nil
+}
+
+func waitDone(t *testing.T, q *memQueue) string {
+	t.Helper()
+	select {
+	case id := <-q.done:
+		return id
+	case <-time.After(2 * time.Second):
+		t.Fatal("enrichment job did not finish")
+		return ""
+	}
+}
+
+func TestAsyncEnrichment(t *testing.T) {
+	logger.Init()
+	q := newMemQueue()
+	geo := &flakyGeoIP{failures: 2, calls: map[string]int{}}
+	us := service.NewUserService(memRepo{q: q}, geo, nil, &service.Config{EnrichmentMode: service.EnrichmentAsync})
+
+	user := &model.User{Name: "Alice", Email: "alice@example.com", IP: "8.8.8.8"}
+	if err := us.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if user.EnrichmentStatus != model.EnrichmentPending || user.Country != "" || geo.calls["8.8.8.8"] != 0 {
+		t.Fatalf("registration must not wait for the lookup, got %+v", user)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := service.NewEnrichmentWorker(q, geo, service.EnrichmentWorkerConfig{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		MaxAttempts:  3,
+		Backoff:      time.Millisecond,
+	})
+	go worker.Run(ctx)
+
+	waitDone(t, q)
+	if q.status(user.ID) != model.EnrichmentDone || user.CountryCode != "UA" {
+		t.Fatalf("want enriched user, got %+v", user)
+	}
+	if geo.calls["8.8.8.8"] != 3 {
+		t.Fatalf("want 2 retries, got %d calls", geo.calls["8.8.8.8"])
+	}
+}
+
+func TestAsyncEnrichmentFailure(t *testing.T) {
+	logger.Init()
+	q := newMemQueue()
+	geo := &flakyGeoIP{failures: 10, calls: map[string]int{}}
+	us := service.NewUserService(memRepo{q: q}, geo, nil, &service.Config{EnrichmentMode: service.EnrichmentAsync})
+
+	for _, u := range []*model.User{
+		{Email: "retries@example.com", IP: "8.8.8.8"},
+		{Email: "unknown@example.com", IP: "1.2.3.4"},
+	} {
+		if err := us.CreateUser(context.Background(), u); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := service.NewEnrichmentWorker(q, geo, service.EnrichmentWorkerConfig{
+		PollInterval: 5 * time.Millisecond,
+		MaxAttempts:  3,
+		Backoff:      time.Millisecond,
+	})
+	go worker.Run(ctx)
+
+	waitDone(t, q)
+	waitDone(t, q)
+	for _, id := range []string{"retries@example.com", "unknown@example.com"} {
+		if got := q.status(id); got != model.EnrichmentFailed {
+			t.Errorf("%s: want failed, got %q", id, got)
+		}
+	}
+
+	geo.mu.Lock()
+	defer geo.mu.Unlock()
+	if geo.calls["8.8.8.8"] != 3 || geo.calls["1.2.3.4"] != 1 {
+		t.Errorf("want 3 attempts and no retry of unknown IP, got %v", geo.calls)
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/ipclass"
+	"ip_detector/internal/logger"
+)
+
+// ErrInvalidIP is returned for input that is not an IP address.
+var ErrInvalidIP = errors.New("invalid IP address")
+
+// lookupBatchSize is how many IPs LookupMany hands to a batch-capable
+// provider at once; it matches the ip-api batch endpoint.
+const lookupBatchSize = 100
+
+// LookupService exposes the configured GeoIP provider as a standalone
+// geolocation API.
+type LookupService struct {
+	geoIP port.GeoIPService
+}
+
+func NewLookupService(geoIP port.GeoIPService) *LookupService {
+	return &LookupService{geoIP: geoIP}
+}
+
+// Lookup resolves a single IP. Addresses that cannot be geolocated are
+// rejected with a model.ReservedIPError before reaching the provider.
+func (s *LookupService) Lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	log := logger.Log.Sugar()
+	log.Infow("lookup called", "ip", ip)
+
+	if err := checkLookupIP(ip); err != nil {
+		return nil, err
+	}
+
+	loc, err := s.geoIP.Lookup(ctx, ip)
+	if err != nil {
+		log.Warnw("lookup failed", "ip", ip, "error", err)
+		return nil, err
+	}
+	return loc, nil
+}
+
+// checkLookupIP rejects input that is not a public IP address.
+func checkLookupIP(ip string) error {
+	class, err := ipclass.ClassifyString(ip)
+	if err != nil {
+		return fmt.Errorf("%w %q", ErrInvalidIP, ip)
+	}
+	if class != ipclass.Public {
+		logger.Log.Sugar().Infow("lookup of reserved IP rejected", "ip", ip, "class", class)
+		return &model.ReservedIPError{IP: ip, Class: string(class)}
+	}
+	return nil
+}
+
+// LookupMany resolves ips and calls emit with each result in input order.
+// A provider that batches natively gets the IPs in batches; otherwise at
+// most concurrency single lookups are in flight. It stops
+// early when ctx is done or emit fails.
+func (s *LookupService) LookupMany(ctx context.Context, ips []string, concurrency int, emit func(model.GeoLookupResult) error) error {
+	if batcher, ok := s.geoIP.(port.BatchGeoIPService); ok && batcher.NativeBatch() {
+		return lookupBatches(ctx, batcher, ips, emit)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	results := make([]chan model.GeoLookupResult, len(ips))
+	for i := range results {
+		results[i] = make(chan model.GeoLookupResult, 1)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				loc, err := s.Lookup(ctx, ips[i])
+				results[i] <- model.GeoLookupResult{IP: ips[i], Location: loc, Err: err}
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	go func() {
+		defer close(jobs)
+		for i := range ips {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := range ips {
+		select {
+		case res := <-results[i]:
+			if err := emit(res); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// lookupBatches resolves ips lookupBatchSize at a time. Invalid and reserved
+// IPs are answered without asking the provider, and a failed batch marks
+// only its own IPs as failed.
+func lookupBatches(ctx context.Context, batcher port.BatchGeoIPService, ips []string, emit func(model.GeoLookupResult) error) error {
+	log := logger.Log.Sugar()
+
+	for start := 0; start < len(ips); start += lookupBatchSize {
+		chunk := ips[start:min(start+lookupBatchSize, len(ips))]
+
+		results := make([]model.GeoLookupResult, len(chunk))
+		var public []string
+		var publicAt []int
+		for i, ip := range chunk {
+			results[i] = model.GeoLookupResult{IP: ip, Err: checkLookupIP(ip)}
+			if results[i].Err == nil {
+				public = append(public, ip)
+				publicAt = append(publicAt, i)
+			}
+		}
+
+		if len(public) > 0 {
+			log.Infow("lookup batch called", "count", len(public))
+			resolved, err := batcher.LookupBatch(ctx, public)
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case err != nil:
+				log.Warnw("lookup batch failed", "count", len(public), "error", err)
+				for _, i := range publicAt {
+					results[i].Err = err
+				}
+			default:
+				for j, i := range publicAt {
+					results[i] = resolved[j]
+				}
+			}
+		}
+
+		for _, res := range results {
+			if err := emit(res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CacheStats reports the GeoIP cache counters. It returns false when the
+// provider is not cached.
+func (s *LookupService) CacheStats() (model.GeoIPCacheStats, bool) {
+	inspector, ok := s.geoIP.(port.GeoIPCacheInspector)
+	if !ok {
+		return model.GeoIPCacheStats{}, false
+	}
+	return inspector.Stats(), true
+}
+
+// DatabaseInfo reports the file-based GeoIP databases in use. It is empty
+// when the configured provider does not load any.
+func (s *LookupService) DatabaseInfo() []model.GeoIPDatabaseInfo {
+	inspector, ok := s.geoIP.(port.GeoIPDatabaseInspector)
+	if !ok {
+		return []model.GeoIPDatabaseInfo{}
+	}
+	if info := inspector.DatabaseInfo(); info != nil {
+		return info
+	}
+	return []model.GeoIPDatabaseInfo{}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/ipclass"
+	"ip_detector/internal/logger"
+)
+
+// ReenrichOptions controls a re-enrichment run. Interval is the minimum
+// time between two provider lookups; without Apply nothing is written.
+type ReenrichOptions struct {
+	Selector model.ReenrichSelector
+	Apply    bool
+	Interval time.Duration
+}
+
+// ReenrichService runs stored users through the GeoIP provider again and
+// reports which countries changed. geoIP should be the uncached provider,
+// so that stale locations are not served again from the cache.
+type ReenrichService struct {
+	repo  port.UserRepository
+	geoIP port.GeoIPService
+}
+
+func NewReenrichService(repo port.UserRepository, geoIP port.GeoIPService) *ReenrichService {
+	return &ReenrichService{repo: repo, geoIP: geoIP}
+}
+
+func (s *ReenrichService) Run(ctx context.Context, opts ReenrichOptions) (*model.ReenrichReport, error) {
+	log := logger.Log.Sugar()
+
+	users, err := s.repo.FindForReenrichment(ctx, opts.Selector)
+	if err != nil {
+		log.Errorw("select users for re-enrichment failed", "error", err)
+		return nil, fmt.Errorf("failed to select users: %w", err)
+	}
+	log.Infow("re-enrichment started", "users", len(users), "apply", opts.Apply)
+
+	report := &model.ReenrichReport{DryRun: !opts.Apply, Selected: len(users), Changes: []model.ReenrichChange{}}
+
+	var next time.Time
+	for _, u := range users {
+		change := model.ReenrichChange{
+			UserID:         u.ID,
+			Email:          u.Email,
+			IP:             u.IP,
+			OldCountryCode: u.CountryCode,
+			OldCountry:     u.Country,
+		}
+
+		if err := waitUntil(ctx, next); err != nil {
+			return report, err
+		}
+		next = time.Now().Add(opts.Interval)
+
+		loc, err := s.lookup(ctx, u.IP)
+		if err != nil {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			log.Warnw("re-enrichment lookup failed", "id", u.ID, "ip", u.IP, "error", err)
+			change.Error = err.Error()
+			report.Failed++
+			report.Changes = append(report.Changes, change)
+			continue
+		}
+
+		if opts.Apply {
+			err := s.repo.UpdateGeoLocation(ctx, u.ID, u.IP, loc)
+			if errors.Is(err, model.ErrNotFound) {
+				log.Infow("re-enrichment skipped, user changed meanwhile", "id", u.ID, "ip", u.IP)
+				report.Skipped++
+				continue
+			}
+			if err != nil {
+				log.Errorw("re-enrichment update failed", "id", u.ID, "error", err)
+				return report, fmt.Errorf("failed to update user %s: %w", u.ID, err)
+			}
+		}
+
+		if loc.CountryCode == u.CountryCode && loc.Country == u.Country {
+			report.Unchanged++
+		} else {
+			change.NewCountryCode = loc.CountryCode
+			change.NewCountry = loc.Country
+			report.Changed++
+			report.Changes = append(report.Changes, change)
+		}
+	}
+
+	log.Infow("re-enrichment finished", "selected", report.Selected, "changed", report.Changed,
+		"failed", report.Failed, "skipped", report.Skipped, "apply", opts.Apply)
+	return report, nil
+}
+
+// lookup resolves a public IP, waiting once for the provider's quota when
+// it is rate limited.
+func (s *ReenrichService) lookup(ctx context.Context, ip string) (*model.GeoLocation, error) {
+	class, err := ipclass.ClassifyString(ip)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrInvalidIP, ip)
+	}
+	if class != ipclass.Public {
+		return nil, &model.ReservedIPError{IP: ip, Class: string(class)}
+	}
+
+	loc, err := s.geoIP.Lookup(ctx, ip)
+	var rateLimited *model.RateLimitError
+	if errors.As(err, &rateLimited) {
+		logger.Log.Sugar().Infow("re-enrichment rate limited, waiting", "retry_after", rateLimited.RetryAfter)
+		if err := waitUntil(ctx, time.Now().Add(rateLimited.RetryAfter)); err != nil {
+			return nil, err
+		}
+		return s.geoIP.Lookup(ctx, ip)
+	}
+	return loc, err
+}
+
+func waitUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ip_detector/internal/auth"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// RevocationService decides whether an unexpired access token is still
+// accepted. Single tokens are revoked by ID; revoking all sessions of a
+// user sets a watermark before which all of the user's tokens are invalid.
+//
+// Checks are answered from memory. Revocations made by this process apply
+// immediately, those of other processes after the next Sync.
+type RevocationService struct {
+	store    port.TokenRevocationStore
+	refresh  port.RefreshTokenStore
+	tokenTTL time.Duration
+
+	mu         sync.RWMutex
+	revoked    map[string]time.Time // token ID -> token expiry
+	validAfter map[string]time.Time // user ID (token subject) -> watermark
+}
+
+// NewRevocationService creates the service. Call Sync before serving to
+// load the revocations stored so far.
+func NewRevocationService(store port.TokenRevocationStore, refresh port.RefreshTokenStore, cfg *Config) *RevocationService {
+	ttl, err := time.ParseDuration(cfg.JWTExpiration)
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &RevocationService{
+		store:      store,
+		refresh:    refresh,
+		tokenTTL:   ttl,
+		revoked:    map[string]time.Time{},
+		validAfter: map[string]time.Time{},
+	}
+}
+
+// IsRevoked reports whether the token was revoked, or issued before its
+// subject's watermark. iat has whole-second precision, so tokens issued in
+// the same second as a watermark are rejected as well.
+func (s *RevocationService) IsRevoked(claims *auth.Claims) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.revoked[claims.ID]; ok && claims.ID != "" {
+		return true
+	}
+	watermark, ok := s.validAfter[claims.Subject]
+	if !ok {
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Before(watermark)
+}
+
+// RevokeToken revokes a single access token until it expires.
+func (s *RevocationService) RevokeToken(ctx context.Context, claims *auth.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token has no ID or expiry")
+	}
+
+	if err := s.store.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	s.mu.Lock()
+	s.revoked[claims.ID] = claims.ExpiresAt.Time
+	s.mu.Unlock()
+
+	logger.Log.Sugar().Infow("access token revoked", "user", claims.Subject, "jti", claims.ID)
+	return nil
+}
+
+// RevokeSessions invalidates every access token issued to the user so far
+// and all of the user's refresh tokens. It also works for deleted users.
+func (s *RevocationService) RevokeSessions(ctx context.Context, userID string) error {
+	log := logger.Log.Sugar()
+
+	now := time.Now()
+	if err := s.store.SetTokensValidAfter(ctx, userID, now); err != nil {
+		log.Warnw("revoke sessions failed", "id", userID, "error", err)
+		return err
+	}
+	s.sessionsRevoked(userID, now)
+
+	if err := s.refresh.RevokeUser(ctx, userID); err != nil {
+		log.Errorw("revoke refresh tokens failed", "id", userID, "error", err)
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	log.Infow("sessions revoked", "id", userID)
+	return nil
+}
+
+// sessionsRevoked applies a watermark that is already stored, e.g. by
+// deleting the user.
+func (s *RevocationService) sessionsRevoked(userID string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.After(s.validAfter[userID]) {
+		s.validAfter[userID] = at
+	}
+}
+
+// Sync deletes expired revocations and refresh tokens and merges the stored
+// revocations into memory. Entries that can no longer match an unexpired
+// token are dropped.
+func (s *RevocationService) Sync(ctx context.Context) error {
+	log := logger.Log.Sugar()
+
+	if n, err := s.store.DeleteExpired(ctx); err != nil {
+		log.Warnw("delete expired revocations failed", "error", err)
+	} else if n > 0 {
+		log.Infow("expired revocations deleted", "count", n)
+	}
+	if n, err := s.refresh.DeleteExpired(ctx); err != nil {
+		log.Warnw("delete expired refresh tokens failed", "error", err)
+	} else if n > 0 {
+		log.Infow("expired refresh tokens deleted", "count", n)
+	}
+
+	now := time.Now()
+	revoked, err := s.store.ListRevoked(ctx)
+	if err != nil {
+		return err
+	}
+	validAfter, err := s.store.ListTokensValidAfter(ctx, now.Add(-s.tokenTTL))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, exp := range revoked {
+		s.revoked[jti] = exp
+	}
+	for id, t := range validAfter {
+		if t.After(s.validAfter[id]) {
+			s.validAfter[id] = t
+		}
+	}
+	for jti, exp := range s.revoked {
+		if !exp.After(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	for id, t := range s.validAfter {
+		if t.Add(s.tokenTTL).Before(now) {
+			delete(s.validAfter, id)
+		}
+	}
+	return nil
+}
+
+// DefaultRevocationSyncInterval is used when Run gets no positive interval.
+const DefaultRevocationSyncInterval = 30 * time.Second
+
+// Run calls Sync every interval until ctx is done.
+func (s *RevocationService) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRevocationSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sync(ctx); err != nil {
+				logger.Log.Sugar().Warnw("token revocation sync failed", "error", err)
+			}
+		}
+	}
+}
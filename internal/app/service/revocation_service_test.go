@@ -0,0 +1,183 @@
+package service_test
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"ip_detector/internal/app/service"
+	"ip_detector/internal/auth"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// sharedRevocations is a revocation store shared by several "processes".
+type sharedRevocations struct {
+	port.TokenRevocationStore
+	revoked    map[string]time.Time
+	validAfter map[string]time.Time
+}
+
+func (s *sharedRevocations) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	s.revoked[jti] = expiresAt
+	return nil
+}
+func (s *sharedRevocations) ListRevoked(context.Context) (map[string]time.Time, error) {
+	return maps.Clone(s.revoked), nil
+}
+func (s *sharedRevocations) DeleteExpired(context.Context) (int64, error) {
+	var n int64
+	for jti, exp := range s.revoked {
+		if !exp.After(time.Now()) {
+			delete(s.revoked, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+func (s *sharedRevocations) ListTokensValidAfter(_ context.Context, since time.Time) (map[string]time.Time, error) {
+	out := map[string]time.Time{}
+	for id, t := range s.validAfter {
+		if t.After(since) {
+			out[id] = t
+		}
+	}
+	return out, nil
+}
+
+func (s *sharedRevocations) SetTokensValidAfter(_ context.Context, userID string, t time.Time) error {
+	s.validAfter[userID] = t
+	return nil
+}
+
+type revokedRefreshTokens struct {
+	port.RefreshTokenStore
+	users  []string
+	sweeps int
+}
+
+func (s *revokedRefreshTokens) RevokeUser(_ context.Context, userID string) error {
+	s.users = append(s.users, userID)
+	return nil
+}
+
+func (s *revokedRefreshTokens) DeleteExpired(context.Context) (int64, error) {
+	s.sweeps++
+	return 0, nil
+}
+
+// roleRepo holds a single user.
+type roleRepo struct {
+	port.UserRepository
+	user        model.User
+	sessionsEnd time.Time
+}
+
+func (r *roleRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	if email != r.user.Email {
+		return nil, model.ErrNotFound
+	}
+	u := r.user
+	return &u, nil
+}
+func (r *roleRepo) SetRole(_ context.Context, _ string, role model.Role, at time.Time) error {
+	r.user.Role = role
+	r.sessionsEnd = at
+	return nil
+}
+
+func claims(jti, userID string, issued, expires time.Time) *auth.Claims {
+	return &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(issued),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}}
+}
+
+func TestRevocationSync(t *testing.T) {
+	logger.Init()
+	ctx := context.Background()
+	store := &sharedRevocations{revoked: map[string]time.Time{}, validAfter: map[string]time.Time{}}
+	cfg := &service.Config{JWTExpiration: "15m"}
+	refresh := &revokedRefreshTokens{}
+	a := service.NewRevocationService(store, refresh, cfg)
+	b := service.NewRevocationService(store, refresh, cfg)
+
+	now := time.Now()
+	tok := claims("jti-1", "user-1", now.Add(-time.Minute), now.Add(10*time.Minute))
+	if err := a.RevokeToken(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsRevoked(tok) {
+		t.Fatal("want revocation to apply locally at once")
+	}
+	if b.IsRevoked(tok) {
+		t.Fatal("want other process to see the revocation only after sync")
+	}
+	if err := b.Sync(ctx); err != nil || !b.IsRevoked(tok) {
+		t.Fatalf("want revocation after sync, err %v", err)
+	}
+	if refresh.sweeps != 1 {
+		t.Fatalf("want sync to delete expired refresh tokens, got %d sweeps", refresh.sweeps)
+	}
+
+	store.validAfter["user-2"] = now
+	if err := b.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !b.IsRevoked(claims("jti-2", "user-2", now.Add(-time.Minute), now.Add(time.Minute))) {
+		t.Fatal("want token issued before the watermark to be revoked")
+	}
+	if b.IsRevoked(claims("jti-3", "user-2", now.Add(2*time.Second), now.Add(time.Minute))) {
+		t.Fatal("want token issued after the watermark to be accepted")
+	}
+
+	store.revoked["jti-1"] = now.Add(-time.Second)
+	gone := claims("jti-4", "user-3", now.Add(-time.Hour), now.Add(-time.Second))
+	_ = b.RevokeToken(ctx, gone)
+	if err := b.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.revoked) != 0 || b.IsRevoked(gone) {
+		t.Fatalf("want expired revocations cleaned up, store has %v", store.revoked)
+	}
+}
+
+func TestRevocationRunWithoutInterval(t *testing.T) {
+	logger.Init()
+	store := &sharedRevocations{revoked: map[string]time.Time{}, validAfter: map[string]time.Time{}}
+	s := service.NewRevocationService(store, &revokedRefreshTokens{}, &service.Config{JWTExpiration: "15m"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx, 0) // must not panic
+}
+
+func TestSetRoleRevokesSessions(t *testing.T) {
+	logger.Init()
+	ctx := context.Background()
+	store := &sharedRevocations{revoked: map[string]time.Time{}, validAfter: map[string]time.Time{}}
+	refresh := &revokedRefreshTokens{}
+	cfg := &service.Config{JWTExpiration: "15m"}
+	revocations := service.NewRevocationService(store, refresh, cfg)
+	repo := &roleRepo{user: model.User{ID: "user-1", Email: "ann@example.com", Role: model.RoleAdmin}}
+	users := service.NewUserService(repo, nil, revocations, cfg)
+
+	adminToken := claims("jti-1", "user-1", time.Now().Add(-time.Minute), time.Now().Add(10*time.Minute))
+	if _, err := users.SetRole(ctx, "ann@example.com", model.RoleAdmin); err != nil || revocations.IsRevoked(adminToken) {
+		t.Fatalf("want unchanged role to keep sessions, err %v", err)
+	}
+
+	user, err := users.SetRole(ctx, "ann@example.com", model.RoleUser)
+	if err != nil || user.Role != model.RoleUser {
+		t.Fatalf("want demoted user, got %+v, %v", user, err)
+	}
+	if !revocations.IsRevoked(adminToken) || repo.sessionsEnd.IsZero() {
+		t.Fatal("want demotion to end the sessions with the role change")
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ip_detector/internal/auth"
+	"ip_detector/internal/domain/model"
+	"ip_detector/internal/domain/port"
+	"ip_detector/internal/logger"
+)
+
+// DefaultRefreshTokenTTL is used when Config.RefreshTokenTTL is not set.
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// TokenService issues short-lived access tokens together with opaque
+// refresh tokens. Each login starts a refresh token family; every refresh
+// rotates the token within its family, and presenting a rotated token
+// again revokes the whole family.
+type TokenService struct {
+	users       port.UserRepository
+	store       port.RefreshTokenStore
+	revocations *RevocationService
+	Config      *Config
+}
+
+func NewTokenService(users port.UserRepository, store port.RefreshTokenStore, revocations *RevocationService, cfg *Config) *TokenService {
+	return &TokenService{users: users, store: store, revocations: revocations, Config: cfg}
+}
+
+// Issue starts a new session for an authenticated user.
+func (s *TokenService) Issue(ctx context.Context, user *model.User) (*model.TokenPair, error) {
+	log := logger.Log.Sugar()
+	log.Infow("issuing tokens", "email", user.Email, "role", user.Role)
+
+	refresh, token, err := s.newRefreshToken(user, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Create(ctx, refresh); err != nil {
+		log.Errorw("store refresh token failed", "id", user.ID, "error", err)
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return s.pair(user, token)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented
+// token becomes unusable; presenting it again yields
+// model.ErrRefreshTokenReused and ends the session.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (*model.TokenPair, error) {
+	log := logger.Log.Sugar()
+
+	old, err := s.lookup(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if old.UsedAt != nil {
+		log.Warnw("refresh token reused, revoking family", "user", old.UserID, "family", old.FamilyID)
+		s.revokeFamily(ctx, old.FamilyID)
+		return nil, model.ErrRefreshTokenReused
+	}
+
+	user, err := s.users.GetByID(ctx, old.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Warnw("refresh for deleted user", "user", old.UserID)
+		s.revokeFamily(ctx, old.FamilyID)
+		return nil, model.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		log.Errorw("refresh: load user failed", "user", old.UserID, "error", err)
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	next, token, err := s.newRefreshToken(user, old.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	switch err := s.store.Rotate(ctx, old, next); {
+	case errors.Is(err, model.ErrRefreshTokenReused):
+		log.Warnw("refresh token rotated concurrently, revoking family", "user", old.UserID, "family", old.FamilyID)
+		s.revokeFamily(ctx, old.FamilyID)
+		return nil, err
+	case err != nil:
+		log.Errorw("rotate refresh token failed", "user", old.UserID, "error", err)
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	log.Infow("tokens refreshed", "user", user.ID, "family", old.FamilyID)
+	return s.pair(user, token)
+}
+
+// Logout revokes the family of refreshToken and, when given, the access
+// token. Unknown, expired and already revoked tokens are ignored.
+func (s *TokenService) Logout(ctx context.Context, refreshToken, accessToken string) error {
+	log := logger.Log.Sugar()
+
+	if accessToken != "" {
+		if claims, err := auth.ParseToken(s.Config.Keys, accessToken); err == nil {
+			if err := s.revocations.RevokeToken(ctx, claims); err != nil {
+				log.Errorw("revoke access token failed", "user", claims.Subject, "error", err)
+				return fmt.Errorf("failed to revoke access token: %w", err)
+			}
+		}
+	}
+
+	old, err := s.lookup(ctx, refreshToken)
+	if errors.Is(err, model.ErrInvalidRefreshToken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.RevokeFamily(ctx, old.FamilyID); err != nil {
+		log.Errorw("revoke refresh token family failed", "family", old.FamilyID, "error", err)
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	log.Infow("logged out", "user", old.UserID, "family", old.FamilyID)
+	return nil
+}
+
+// lookup finds the stored token, reporting unknown, revoked and expired
+// ones as model.ErrInvalidRefreshToken. Used tokens are returned so the
+// caller can detect reuse.
+func (s *TokenService) lookup(ctx context.Context, refreshToken string) (*model.RefreshToken, error) {
+	if refreshToken == "" {
+		return nil, model.ErrInvalidRefreshToken
+	}
+
+	stored, err := s.store.GetByHash(ctx, auth.HashRefreshToken(refreshToken))
+	if errors.Is(err, model.ErrNotFound) {
+		return nil, model.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorw("refresh token lookup failed", "error", err)
+		return nil, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+
+	if stored.RevokedAt != nil || !time.Now().Before(stored.ExpiresAt) {
+		return nil, model.ErrInvalidRefreshToken
+	}
+	return stored, nil
+}
+
+// revokeFamily revokes a family on a security event. The error is only
+// logged, since the caller is rejected either way.
+func (s *TokenService) revokeFamily(ctx context.Context, familyID string) {
+	if err := s.store.RevokeFamily(ctx, familyID); err != nil {
+		logger.Log.Sugar().Errorw("revoke refresh token family failed", "family", familyID, "error", err)
+	}
+}
+
+func (s *TokenService) newRefreshToken(user *model.User, familyID string) (*model.RefreshToken, string, error) {
+	token, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	ttl := s.Config.RefreshTokenTTL
+	if ttl <= 0 {
+		ttl = DefaultRefreshTokenTTL
+	}
+	return &model.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	}, token, nil
+}
+
+func (s *TokenService) pair(user *model.User, refreshToken string) (*model.TokenPair, error) {
+	ttl, err := time.ParseDuration(s.Config.JWTExpiration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT expiration: %w", err)
+	}
+
+	token, err := auth.GenerateToken(s.Config.Keys, user.ID, string(user.Role), s.Config.JWTExpiration)
+	if err != nil {
+		logger.Log.Sugar().Errorw("generate JWT failed", "id", user.ID, "error", err)
+		return nil, fmt.Errorf("failed to generate JWT: %w", err)
+	}
+
+	return &model.TokenPair{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(ttl.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
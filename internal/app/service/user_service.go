@@ -2,30 +2,99 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"strings"
+	"time"
 
 	"ip_detector/internal/auth"
 	"ip_detector/internal/domain/model"
 	"ip_detector/internal/domain/port"
+	"ip_detector/internal/ipclass"
 	"ip_detector/internal/logger"
 )
 
 type UserService struct {
-	repo   port.UserRepository
-	geoIP  port.GeoIPService
-	Config *Config
+	repo        port.UserRepository
+	geoIP       port.GeoIPService
+	revocations *RevocationService
+	Config      *Config
 }
 
 type Config struct {
-	JWTSecret     string
+	// Keys sign new access tokens and verify presented ones.
+	Keys          *auth.KeySet
 	JWTExpiration string
+	// RefreshTokenTTL is how long a refresh token stays valid; each refresh
+	// issues a new one.
+	RefreshTokenTTL time.Duration
+
+	ReservedIPPolicy      ReservedIPPolicy
+	ReservedIPCountryCode string
+
+	EnrichmentMode EnrichmentMode
+}
+
+// ReservedIPPolicy decides what happens to IPs that are not publicly routable
+// and therefore cannot be geolocated.
+type ReservedIPPolicy string
+
+const (
+	// ReservedIPReject refuses such IPs with a model.ReservedIPError.
+	ReservedIPReject ReservedIPPolicy = "reject"
+	// ReservedIPAllow accepts them and leaves the location empty.
+	ReservedIPAllow ReservedIPPolicy = "allow"
+	// ReservedIPDefault accepts them with Config.ReservedIPCountryCode.
+	ReservedIPDefault ReservedIPPolicy = "default"
+)
+
+// ParseReservedIPPolicy parses a policy name, case-insensitively.
+// ReservedIPDefault needs the country code it assigns.
+func ParseReservedIPPolicy(s, countryCode string) (ReservedIPPolicy, error) {
+	switch p := ReservedIPPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case ReservedIPReject, ReservedIPAllow:
+		return p, nil
+	case ReservedIPDefault:
+		if countryCode == "" {
+			return "", errors.New("policy default needs a country code")
+		}
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported reserved IP policy %q, want reject, allow or default", s)
+	}
+}
+
+// EnrichmentMode decides whether registration waits for the GeoIP lookup.
+type EnrichmentMode string
+
+const (
+	// EnrichmentSync resolves the location before the user is saved.
+	EnrichmentSync EnrichmentMode = "sync"
+	// EnrichmentAsync saves the user as pending and leaves the lookup to an
+	// EnrichmentWorker.
+	EnrichmentAsync EnrichmentMode = "async"
+)
+
+// ParseEnrichmentMode parses a mode name, case-insensitively.
+func ParseEnrichmentMode(s string) (EnrichmentMode, error) {
+	switch m := EnrichmentMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case EnrichmentSync, EnrichmentAsync:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported enrichment mode %q, want sync or async", s)
+	}
 }
 
-func NewUserService(repo port.UserRepository, geoIP port.GeoIPService, cfg *Config) *UserService {
+// NewUserService creates the service. In EnrichmentAsync mode repo queues
+// the lookups of pending users. revocations, if not nil, learns about the
+// sessions ended by deleting a user or changing its role.
+func NewUserService(repo port.UserRepository, geoIP port.GeoIPService, revocations *RevocationService, cfg *Config) *UserService {
 	return &UserService{
-		repo:   repo,
-		geoIP:  geoIP,
-		Config: cfg,
+		repo:        repo,
+		geoIP:       geoIP,
+		revocations: revocations,
+		Config:      cfg,
 	}
 }
 
@@ -33,79 +102,327 @@ func (s *UserService) CreateUser(ctx context.Context, user *model.User) error {
 	log := logger.Log.Sugar()
 	log.Infow("create user called", "email", user.Email, "ip", user.IP)
 
-	if user.IP == "" {
-		log.Warn("user IP is empty")
-		return fmt.Errorf("user IP is required")
+	class, err := classifyUserIP(user.IP)
+	if err != nil {
+		log.Warnw("user IP is invalid", "ip", user.IP, "error", err)
+		return err
 	}
 
-	country, err := s.geoIP.GetCountryByIP(user.IP)
-	if err != nil {
-		log.Errorw("geoIP lookup failed", "ip", user.IP, "error", err)
-		return fmt.Errorf("failed to enrich user with country: %w", err)
+	if err := s.checkEmailAvailable(ctx, user.Email); err != nil {
+		return err
+	}
+
+	user.Role = model.RoleUser
+
+	if err := s.locate(ctx, user, class); err != nil {
+		return err
 	}
-	user.Country = country
 
 	if err := s.repo.Save(ctx, user); err != nil {
 		log.Errorw("save user failed", "email", user.Email, "error", err)
 		return fmt.Errorf("failed to save user: %w", err)
 	}
 
-	log.Infow("user saved", "id", user.ID, "email", user.Email, "country", user.Country)
+	log.Infow("user saved", "id", user.ID, "email", user.Email, "role", user.Role, "country", user.Country, "enrichment", user.EnrichmentStatus)
 	return nil
 }
 
-func (s *UserService) GetAllUsers(ctx context.Context) ([]*model.User, error) {
+func classifyUserIP(ip string) (ipclass.Class, error) {
+	if ip == "" {
+		return "", fmt.Errorf("%w: IP is required", ErrInvalidIP)
+	}
+	class, err := ipclass.ClassifyString(ip)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidIP, err)
+	}
+	return class, nil
+}
+
+// checkEmailAvailable runs before the GeoIP lookup so a taken email does
+// not cost one; the unique index still catches concurrent registrations.
+func (s *UserService) checkEmailAvailable(ctx context.Context, email string) error {
 	log := logger.Log.Sugar()
-	log.Info("get all users")
 
-	users, err := s.repo.GetAll(ctx)
+	switch _, err := s.repo.GetByEmail(ctx, email); {
+	case err == nil:
+		log.Warnw("email already registered", "email", email)
+		return model.ErrDuplicateEmail
+	case !errors.Is(err, model.ErrNotFound):
+		log.Errorw("email check failed", "email", email, "error", err)
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	return nil
+}
+
+// locate sets the user's location for its IP: by policy for reserved IPs,
+// as pending in EnrichmentAsync mode, otherwise from the GeoIP provider.
+func (s *UserService) locate(ctx context.Context, user *model.User, class ipclass.Class) error {
+	log := logger.Log.Sugar()
+
+	switch {
+	case class != ipclass.Public:
+		loc, err := s.reservedLocation(user.IP, class)
+		if err != nil {
+			log.Warnw("reserved IP rejected", "ip", user.IP, "class", class)
+			return err
+		}
+		log.Infow("reserved IP accepted by policy", "ip", user.IP, "class", class, "policy", s.Config.ReservedIPPolicy)
+		applyEnrichment(user, loc)
+	case s.Config.EnrichmentMode == EnrichmentAsync:
+		user.ApplyGeoLocation(&model.GeoLocation{IP: user.IP})
+		user.EnrichmentStatus = model.EnrichmentPending
+		user.EnrichedAt = nil
+	default:
+		loc, err := s.geoIP.Lookup(ctx, user.IP)
+		if err != nil {
+			log.Errorw("geoIP lookup failed", "ip", user.IP, "error", err)
+			return fmt.Errorf("failed to enrich user with country: %w", err)
+		}
+		applyEnrichment(user, loc)
+	}
+	return nil
+}
+
+// UserUpdate is a partial update of a user; nil fields stay unchanged.
+type UserUpdate struct {
+	Name  *string
+	Email *string
+	IP    *string
+}
+
+// UpdateUser applies upd to the user with id on behalf of actor, who must
+// be that user or an admin. A changed IP is geolocated again.
+func (s *UserService) UpdateUser(ctx context.Context, actor *model.User, id string, upd UserUpdate) (*model.User, error) {
+	log := logger.Log.Sugar()
+	log.Infow("update user called", "id", id, "actor", actor.ID)
+
+	if !canAccessUser(actor, id) {
+		log.Warnw("update user forbidden", "id", id, "actor", actor.ID)
+		return nil, model.ErrForbidden
+	}
+
+	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		log.Errorw("get all users failed", "error", err)
+		log.Warnw("update user: lookup failed", "id", id, "error", err)
 		return nil, err
 	}
 
-	log.Infow("users fetched", "count", len(users))
-	return users, nil
+	if upd.Name != nil {
+		user.Name = *upd.Name
+	}
+	if upd.Email != nil && *upd.Email != user.Email {
+		if err := s.checkEmailAvailable(ctx, *upd.Email); err != nil {
+			return nil, err
+		}
+		user.Email = *upd.Email
+	}
+	if upd.IP != nil && !sameAddr(*upd.IP, user.IP) {
+		class, err := classifyUserIP(*upd.IP)
+		if err != nil {
+			log.Warnw("user IP is invalid", "ip", *upd.IP, "error", err)
+			return nil, err
+		}
+		user.IP = *upd.IP
+		if err := s.locate(ctx, user, class); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		log.Errorw("update user failed", "id", id, "error", err)
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	log.Infow("user updated", "id", id, "country", user.Country)
+	return user, nil
 }
 
-func (s *UserService) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+// DeleteUser soft-deletes the user with id on behalf of actor, who must be
+// that user or an admin, and revokes all of the user's tokens.
+func (s *UserService) DeleteUser(ctx context.Context, actor *model.User, id string) error {
 	log := logger.Log.Sugar()
-	log.Infow("get user by id", "id", id)
+	log.Infow("delete user called", "id", id, "actor", actor.ID)
+
+	if !canAccessUser(actor, id) {
+		log.Warnw("delete user forbidden", "id", id, "actor", actor.ID)
+		return model.ErrForbidden
+	}
+
+	now := time.Now()
+	if err := s.repo.SoftDelete(ctx, id, now); err != nil {
+		log.Warnw("delete user failed", "id", id, "error", err)
+		return err
+	}
+	if s.revocations != nil {
+		s.revocations.sessionsRevoked(id, now)
+	}
+
+	log.Infow("user deleted", "id", id)
+	return nil
+}
+
+// canAccessUser is the access policy for single users: everyone may read
+// and change their own account, admins every account.
+func canAccessUser(actor *model.User, id string) bool {
+	return actor.IsAdmin() || actor.ID == id
+}
+
+// SetRole gives the user with email the role. It is meant for operators,
+// e.g. to promote an existing user to admin. A changed role ends the user's
+// sessions, since the role claim of issued tokens is outdated.
+func (s *UserService) SetRole(ctx context.Context, email string, role model.Role) (*model.User, error) {
+	log := logger.Log.Sugar()
+	log.Infow("set role called", "email", email, "role", role)
+
+	if !role.Valid() {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		log.Warnw("set role: lookup failed", "email", email, "error", err)
+		return nil, err
+	}
+
+	if user.Role == role {
+		log.Infow("role unchanged", "id", user.ID, "role", role)
+		return user, nil
+	}
+
+	now := time.Now()
+	if err := s.repo.SetRole(ctx, user.ID, role, now); err != nil {
+		log.Errorw("set role failed", "id", user.ID, "error", err)
+		return nil, fmt.Errorf("failed to set role: %w", err)
+	}
+	user.Role = role
+	if s.revocations != nil {
+		s.revocations.sessionsRevoked(user.ID, now)
+	}
+
+	log.Infow("role set", "id", user.ID, "role", role)
+	return user, nil
+}
+
+// sameAddr reports whether a and b are the same IP in any notation.
+func sameAddr(a, b string) bool {
+	x, errA := netip.ParseAddr(a)
+	y, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return x.Unmap() == y.Unmap()
+}
+
+func applyEnrichment(user *model.User, loc *model.GeoLocation) {
+	now := time.Now()
+	user.ApplyGeoLocation(loc)
+	user.EnrichmentStatus = model.EnrichmentDone
+	user.EnrichedAt = &now
+}
+
+// reservedLocation applies the configured ReservedIPPolicy to an IP that
+// cannot be geolocated.
+func (s *UserService) reservedLocation(ip string, class ipclass.Class) (*model.GeoLocation, error) {
+	switch s.Config.ReservedIPPolicy {
+	case ReservedIPAllow:
+		return &model.GeoLocation{IP: ip}, nil
+	case ReservedIPDefault:
+		code := s.Config.ReservedIPCountryCode
+		return &model.GeoLocation{IP: ip, CountryCode: code, Country: code, Source: "default"}, nil
+	default:
+		return nil, &model.ReservedIPError{IP: ip, Class: string(class)}
+	}
+}
+
+const (
+	// DefaultUserPageSize is used when a UserQuery has no limit.
+	DefaultUserPageSize = 50
+	// MaxUserPageSize caps the limit of a UserQuery.
+	MaxUserPageSize = 200
+)
+
+// QueryUsers returns one page of users, applying the default and maximum
+// page size.
+func (s *UserService) QueryUsers(ctx context.Context, q model.UserQuery) (*model.UserPage, error) {
+	log := logger.Log.Sugar()
+	log.Infow("query users", "query", q)
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultUserPageSize
+	}
+	if q.Limit > MaxUserPageSize {
+		q.Limit = MaxUserPageSize
+	}
+
+	page, err := s.repo.Query(ctx, q)
+	if err != nil {
+		log.Errorw("query users failed", "error", err)
+		return nil, err
+	}
+
+	log.Infow("users fetched", "count", len(page.Users), "more", page.NextCursor != "")
+	return page, nil
+}
+
+// GetUserByID returns the user with id to actor, who must be that user or
+// an admin.
+func (s *UserService) GetUserByID(ctx context.Context, actor *model.User, id string) (*model.User, error) {
+	log := logger.Log.Sugar()
+	log.Infow("get user by id", "id", id, "actor", actor.ID)
+
+	if !canAccessUser(actor, id) {
+		log.Warnw("get user forbidden", "id", id, "actor", actor.ID)
+		return nil, model.ErrForbidden
+	}
 
 	u, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrInvalidID) {
+		log.Infow("user not found", "id", id, "error", err)
+		return nil, err
+	}
 	if err != nil {
 		log.Errorw("get user by id failed", "id", id, "error", err)
 		return nil, err
 	}
-	if u == nil {
-		log.Infow("user not found", "id", id)
-	}
 	return u, nil
 }
 
+// GetCurrentUser returns the user authenticated with id, e.g. by a token
+// subject.
+func (s *UserService) GetCurrentUser(ctx context.Context, id string) (*model.User, error) {
+	u, err := s.repo.GetByID(ctx, id)
+	if err != nil && !errors.Is(err, model.ErrNotFound) && !errors.Is(err, model.ErrInvalidID) {
+		logger.Log.Sugar().Errorw("get current user failed", "id", id, "error", err)
+	}
+	return u, err
+}
+
 func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	log := logger.Log.Sugar()
 	log.Infow("get user by email", "email", email)
 
 	u, err := s.repo.GetByEmail(ctx, email)
+	if errors.Is(err, model.ErrNotFound) {
+		log.Infow("user not found", "email", email)
+		return nil, err
+	}
 	if err != nil {
 		log.Errorw("get user by email failed", "email", email, "error", err)
 		return nil, err
 	}
-	if u == nil {
-		log.Infow("user not found", "email", email)
-	}
 	return u, nil
 }
 
-func (s *UserService) GenerateJWT(email string) (string, error) {
+// RecordLogin stores the login time and client IP on the user. Failures are
+// only logged, they must not fail the login itself.
+func (s *UserService) RecordLogin(ctx context.Context, user *model.User, ip string) {
 	log := logger.Log.Sugar()
-	log.Infow("generating JWT", "email", email)
 
-	token, err := auth.GenerateToken(email, s.Config.JWTSecret, s.Config.JWTExpiration)
-	if err != nil {
-		log.Errorw("generate JWT failed", "email", email, "error", err)
-		return "", fmt.Errorf("failed to generate JWT: %w", err)
+	now := time.Now()
+	if err := s.repo.RecordLogin(ctx, user.ID, ip, now); err != nil {
+		log.Errorw("record login failed", "id", user.ID, "error", err)
+		return
 	}
-	return token, nil
+	user.LastLoginAt = &now
+	user.LastLoginIP = ip
 }
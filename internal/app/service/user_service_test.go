@@ -0,0 +1,31 @@
+package service_test
+
+import (
+	"testing"
+
+	"ip_detector/internal/app/service"
+)
+
+func TestParseReservedIPPolicy(t *testing.T) {
+	if p, err := service.ParseReservedIPPolicy(" Allow ", ""); err != nil || p != service.ReservedIPAllow {
+		t.Fatalf("want allow, got %q, %v", p, err)
+	}
+	if p, err := service.ParseReservedIPPolicy("default", "UA"); err != nil || p != service.ReservedIPDefault {
+		t.Fatalf("want default, got %q, %v", p, err)
+	}
+	if _, err := service.ParseReservedIPPolicy("default", ""); err == nil {
+		t.Fatal("want an error for default without a country code")
+	}
+	if _, err := service.ParseReservedIPPolicy("ignore", "UA"); err == nil {
+		t.Fatal("want an error for an unknown policy")
+	}
+}
+
+func TestParseEnrichmentMode(t *testing.T) {
+	if m, err := service.ParseEnrichmentMode("ASYNC"); err != nil || m != service.EnrichmentAsync {
+		t.Fatalf("want async, got %q, %v", m, err)
+	}
+	if _, err := service.ParseEnrichmentMode("asnyc"); err == nil {
+		t.Fatal("want an error for an unknown mode")
+	}
+}
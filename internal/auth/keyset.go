@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA modulus accepted for RS256 keys.
+const minRSABits = 2048
+
+// Key is a JWT signing or verification key. ID is the "kid" header of the
+// tokens it signs; HMAC keys have an empty ID so tokens issued before key
+// IDs were introduced still verify.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   any // private key or HMAC secret, nil for verification-only keys
+	verifyKey any // public key or HMAC secret
+	public    crypto.PublicKey
+}
+
+// KeySet signs tokens with one key and verifies them with any key it
+// holds, so that during a rotation tokens signed by the previous or next
+// key stay valid.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	methods []string
+}
+
+// NewHMACKeySet returns a key set that signs and verifies with HS256 and
+// the shared secret.
+func NewHMACKeySet(secret string) *KeySet {
+	key := &Key{Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+	ks := &KeySet{}
+	ks.add(key)
+	ks.signing = key
+	return ks
+}
+
+// LoadKeySet builds the key set from PEM files. Tokens are signed with the
+// private key at signingKeyPath, RS256 for RSA and EdDSA for Ed25519 keys;
+// without a path they are signed with HS256 and secret. The keys at
+// verifyKeyPaths, public or private, are only used to verify tokens, as is
+// a non-empty secret next to a signing key, so HS256 tokens issued before
+// the switch stay valid until they expire.
+func LoadKeySet(secret, signingKeyPath string, verifyKeyPaths []string) (*KeySet, error) {
+	ks := NewHMACKeySet(secret)
+
+	if signingKeyPath != "" {
+		key, err := loadKeyFile(signingKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if key.signKey == nil {
+			return nil, fmt.Errorf("%s: signing key must be a private key", signingKeyPath)
+		}
+		hmac := ks.keys[""]
+		ks = &KeySet{}
+		ks.add(key)
+		ks.signing = key
+		if secret != "" {
+			hmac.signKey = nil
+			ks.add(hmac)
+		}
+	}
+
+	for _, path := range verifyKeyPaths {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ks.keys[key.ID]; ok {
+			continue
+		}
+		key.signKey = nil
+		ks.add(key)
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) add(key *Key) {
+	if ks.keys == nil {
+		ks.keys = map[string]*Key{}
+	}
+	ks.keys[key.ID] = key
+
+	if alg := key.Method.Alg(); !slices.Contains(ks.methods, alg) {
+		ks.methods = append(ks.methods, alg)
+	}
+}
+
+// SigningKeyID returns the key ID put into the header of new tokens.
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.ID
+}
+
+// Sign returns the signed token for claims.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	tkn := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		tkn.Header["kid"] = ks.signing.ID
+	}
+	return tkn.SignedString(ks.signing.signKey)
+}
+
+// Parse verifies token with the key named by its "kid" header and decodes
+// it into claims. The token's algorithm must match the key's.
+func (ks *KeySet) Parse(token string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("key %q does not use %s", kid, t.Method.Alg())
+		}
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods(ks.methods))
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, the signing key first and the
+// others by key ID. HMAC keys are secret and never included.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if jwk, ok := ks.signing.jwk(); ok {
+		set.Keys = append(set.Keys, jwk)
+	}
+	for _, id := range slices.Sorted(maps.Keys(ks.keys)) {
+		if key := ks.keys[id]; key != ks.signing {
+			if jwk, ok := key.jwk(); ok {
+				set.Keys = append(set.Keys, jwk)
+			}
+		}
+	}
+	return set
+}
+
+func (k *Key) jwk() (JWK, bool) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint, used as key ID so that
+// every process derives the same ID from the same key.
+func (k *Key) thumbprint() string {
+	jwk, _ := k.jwk()
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func loadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// parseKey reads an RSA or Ed25519 key from PEM: PKCS #8 or PKCS #1
+// private keys, PKIX or PKCS #1 public keys.
+func parseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key Key
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key = Key{Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey, public: &k.PublicKey}
+	case *rsa.PublicKey:
+		key = Key{Method: jwt.SigningMethodRS256, verifyKey: k, public: k}
+	case ed25519.PrivateKey:
+		pub := k.Public().(ed25519.PublicKey)
+		key = Key{Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: pub, public: pub}
+	case ed25519.PublicKey:
+		key = Key{Method: jwt.SigningMethodEdDSA, verifyKey: k, public: k}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, want RSA or Ed25519", parsed)
+	}
+
+	if pub, ok := key.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key has %d bits, want at least %d", pub.N.BitLen(), minRSABits)
+	}
+	key.ID = key.thumbprint()
+	return &key, nil
+}
@@ -0,0 +1,188 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ip_detector/internal/auth"
+)
+
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rsaKey(t *testing.T) (private, public string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	return writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		writePEM(t, "rsa.pub.pem", "PUBLIC KEY", pub)
+}
+
+func ed25519Key(t *testing.T) (private, public string) {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pubDER, _ := x509.MarshalPKIXPublicKey(pub)
+	return writePEM(t, "ed25519.pem", "PRIVATE KEY", der),
+		writePEM(t, "ed25519.pub.pem", "PUBLIC KEY", pubDER)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, oldPub := rsaKey(t)
+	newKey, newPub := ed25519Key(t)
+
+	before, err := auth.LoadKeySet("", oldKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := auth.GenerateToken(before, "ann@example.com", "user", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := auth.ParseToken(before, oldToken); err != nil || claims.Subject != "ann@example.com" {
+		t.Fatalf("want RS256 round trip, got %v, %v", claims, err)
+	}
+
+	// Rotate: sign with the new key, keep verifying with the old one.
+	during, err := auth.LoadKeySet("", newKey, []string{oldPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := auth.GenerateToken(during, "ann@example.com", "admin", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := auth.ParseToken(during, token); err != nil {
+			t.Fatalf("want token to verify during rotation: %v", err)
+		}
+	}
+	if _, err := auth.ParseToken(before, newToken); err == nil {
+		t.Fatal("want token of unknown key to be rejected")
+	}
+
+	jwks := during.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != during.SigningKeyID() || jwks.Keys[0].Kty != "OKP" || jwks.Keys[1].Kty != "RSA" {
+		t.Fatalf("want signing key first, then the old key, got %+v", jwks.Keys)
+	}
+	if jwks.Keys[1].Kid != before.SigningKeyID() {
+		t.Fatalf("want the same key ID from private and public key, got %q and %q", before.SigningKeyID(), jwks.Keys[1].Kid)
+	}
+
+	after, err := auth.LoadKeySet("", newKey, []string{newPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseToken(after, oldToken); err == nil {
+		t.Fatal("want token of retired key to be rejected")
+	}
+}
+
+func TestKeySetRejectsForeignAlgorithms(t *testing.T) {
+	hmacToken, err := auth.GenerateToken(auth.NewHMACKeySet("secret"), "ann@example.com", "user", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, pub := rsaKey(t)
+	keys, err := auth.LoadKeySet("", key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseToken(keys, hmacToken); err == nil {
+		t.Fatal("want HMAC token to be rejected by an RSA key set")
+	}
+
+	// A token that names the RSA key but is signed with HS256 and its
+	// public key as secret must not verify.
+	pubPEM, _ := os.ReadFile(pub)
+	forged, err := auth.GenerateToken(auth.NewHMACKeySet(string(pubPEM)), "eve@example.com", "admin", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseToken(keys, forged); err == nil {
+		t.Fatal("want HS256 token to be rejected")
+	}
+
+	if len(auth.NewHMACKeySet("secret").JWKS().Keys) != 0 {
+		t.Fatal("want HMAC secret to stay out of the JWKS")
+	}
+}
+
+func TestKeySetVerifiesSecretAfterSwitch(t *testing.T) {
+	hmacToken, err := auth.GenerateToken(auth.NewHMACKeySet("secret"), "ann@example.com", "user", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := auth.GenerateToken(auth.NewHMACKeySet("other"), "eve@example.com", "admin", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := rsaKey(t)
+	keys, err := auth.LoadKeySet("secret", key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := auth.ParseToken(keys, hmacToken); err != nil || claims.Subject != "ann@example.com" {
+		t.Fatalf("want HMAC token issued before the switch to verify, got %v, %v", claims, err)
+	}
+	if _, err := auth.ParseToken(keys, otherToken); err == nil {
+		t.Fatal("want token signed with another secret to be rejected")
+	}
+
+	token, err := auth.GenerateToken(keys, "bob@example.com", "user", "15m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ParseToken(auth.NewHMACKeySet("secret"), token); err == nil {
+		t.Fatal("want new tokens to be signed with the RSA key")
+	}
+	if _, err := auth.ParseToken(keys, token); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.JWKS().Keys) != 1 {
+		t.Fatalf("want only the RSA key in the JWKS, got %+v", keys.JWKS())
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub := ed25519Key(t)
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(notPEM, []byte("supersecretkey"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, path := range map[string]string{
+		"missing file": filepath.Join(t.TempDir(), "missing.pem"),
+		"not PEM":      notPEM,
+		"weak RSA key": writePEM(t, "weak.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(weak)),
+		"public key":   pub,
+	} {
+		if _, err := auth.LoadKeySet("", path, nil); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
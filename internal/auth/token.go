@@ -6,36 +6,46 @@ import (
 	"time"
 )
 
-func GenerateToken(email, secret, ttl string) (string, error) {
+// Claims are the JWT claims issued by GenerateToken. The subject is the
+// user's ID, which unlike the email never changes.
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func GenerateToken(keys *KeySet, userID, role, ttl string) (string, error) {
 	d, err := time.ParseDuration(ttl)
 	if err != nil {
 		return "", err
 	}
 
-	claims := jwt.RegisteredClaims{
-		Subject:   email,
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(d)),
+	jti, err := randomID()
+	if err != nil {
+		return "", err
 	}
 
-	tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return tkn.SignedString([]byte(secret))
+	claims := Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(d)),
+		},
+	}
+
+	return keys.Sign(claims)
 }
 
-func ParseToken(token, secret string) (string, error) {
-	parsed, err := jwt.ParseWithClaims(
-		token,
-		&jwt.RegisteredClaims{},
-		func(t *jwt.Token) (any, error) { return []byte(secret), nil },
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
-	)
+func ParseToken(keys *KeySet, token string) (*Claims, error) {
+	parsed, err := keys.Parse(token, &Claims{})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	claims, ok := parsed.Claims.(*jwt.RegisteredClaims)
+	claims, ok := parsed.Claims.(*Claims)
 	if !ok || !parsed.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
-	return claims.Subject, nil
+	return claims, nil
 }
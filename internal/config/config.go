@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +16,52 @@ type Config struct {
 	DBName        string
 	JWTSecret     string
 	JWTExpiration string
+
+	JWTSigningKeyPath string
+	JWTVerifyKeyPaths string
+
+	RefreshTokenTTL             time.Duration
+	TokenRevocationSyncInterval time.Duration
+
+	GeoIPProvider string
+	GeoIPAPIURL   string
+	GeoIPBatchURL string
+	GeoIPMMDBPath string
+
+	GeoIPCIDRPaths        string
+	GeoIPBreakerThreshold int
+	GeoIPBreakerCooldown  time.Duration
+	GeoIPReloadInterval   time.Duration
+	GeoIPCanaryIPs        string
+
+	GeoIPConnectTimeout time.Duration
+	GeoIPReadTimeout    time.Duration
+	GeoIPLookupTimeout  time.Duration
+	GeoIPMaxQuotaWait   time.Duration
+
+	GeoIPCacheSize        int
+	GeoIPCacheTTL         time.Duration
+	GeoIPCacheNegativeTTL time.Duration
+
+	ReservedIPPolicy      string
+	ReservedIPCountryCode string
+
+	TrustedProxies string
+	ClientIPHeader string
+	ClientIPMode   string
+
+	BulkLookupMaxIPs      int
+	BulkLookupConcurrency int
+
+	EnrichmentMode         string
+	EnrichmentWorkers      int
+	EnrichmentPollInterval time.Duration
+	EnrichmentLease        time.Duration
+	EnrichmentMaxAttempts  int
+	EnrichmentBackoff      time.Duration
+	EnrichmentMaxBackoff   time.Duration
+
+	ReenrichInterval time.Duration
 }
 
 func LoadConfig() *Config {
@@ -23,7 +72,53 @@ func LoadConfig() *Config {
 		DBPassword:    getEnv("DB_PASSWORD", "password"),
 		DBName:        getEnv("DB_NAME", "users"),
 		JWTSecret:     getEnv("JWT_SECRET", "supersecretkey"),
-		JWTExpiration: getEnv("JWT_EXPIRATION", "24h"),
+		JWTExpiration: getEnv("JWT_EXPIRATION", "15m"),
+
+		JWTSigningKeyPath: getEnv("JWT_SIGNING_KEY_PATH", ""),
+		JWTVerifyKeyPaths: getEnv("JWT_VERIFY_KEY_PATHS", ""),
+
+		RefreshTokenTTL:             getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		TokenRevocationSyncInterval: getEnvDuration("TOKEN_REVOCATION_SYNC_INTERVAL", 30*time.Second),
+
+		GeoIPProvider: getEnv("GEOIP_PROVIDER", "ipapi"),
+		GeoIPAPIURL:   getEnv("GEOIP_IPAPI_URL", "http://ip-api.com/json"),
+		GeoIPBatchURL: getEnv("GEOIP_IPAPI_BATCH_URL", ""),
+		GeoIPMMDBPath: getEnv("GEOIP_MMDB_PATH", "./data/GeoLite2-Country.mmdb"),
+
+		GeoIPCIDRPaths:        getEnv("GEOIP_CIDR_PATHS", "./data/cidr.csv"),
+		GeoIPBreakerThreshold: getEnvInt("GEOIP_BREAKER_THRESHOLD", 5),
+		GeoIPBreakerCooldown:  getEnvDuration("GEOIP_BREAKER_COOLDOWN", 30*time.Second),
+		GeoIPReloadInterval:   getEnvDuration("GEOIP_RELOAD_INTERVAL", time.Minute),
+		GeoIPCanaryIPs:        getEnv("GEOIP_CANARY_IPS", ""),
+
+		GeoIPConnectTimeout: getEnvDuration("GEOIP_CONNECT_TIMEOUT", 2*time.Second),
+		GeoIPReadTimeout:    getEnvDuration("GEOIP_READ_TIMEOUT", 3*time.Second),
+		GeoIPLookupTimeout:  getEnvDuration("GEOIP_LOOKUP_TIMEOUT", 5*time.Second),
+		GeoIPMaxQuotaWait:   getEnvDuration("GEOIP_MAX_QUOTA_WAIT", 2*time.Second),
+
+		GeoIPCacheSize:        getEnvInt("GEOIP_CACHE_SIZE", 10000),
+		GeoIPCacheTTL:         getEnvDuration("GEOIP_CACHE_TTL", 24*time.Hour),
+		GeoIPCacheNegativeTTL: getEnvDuration("GEOIP_CACHE_NEGATIVE_TTL", time.Minute),
+
+		ReservedIPPolicy:      getEnv("RESERVED_IP_POLICY", "reject"),
+		ReservedIPCountryCode: getEnv("RESERVED_IP_COUNTRY", ""),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+		ClientIPHeader: getEnv("CLIENT_IP_HEADER", "x-forwarded-for"),
+		ClientIPMode:   getEnv("CLIENT_IP_MODE", "body"),
+
+		BulkLookupMaxIPs:      getEnvInt("BULK_LOOKUP_MAX_IPS", 10000),
+		BulkLookupConcurrency: getEnvInt("BULK_LOOKUP_CONCURRENCY", 8),
+
+		EnrichmentMode:         getEnv("ENRICHMENT_MODE", "sync"),
+		EnrichmentWorkers:      getEnvInt("ENRICHMENT_WORKERS", 4),
+		EnrichmentPollInterval: getEnvDuration("ENRICHMENT_POLL_INTERVAL", time.Second),
+		EnrichmentLease:        getEnvDuration("ENRICHMENT_LEASE", time.Minute),
+		EnrichmentMaxAttempts:  getEnvInt("ENRICHMENT_MAX_ATTEMPTS", 5),
+		EnrichmentBackoff:      getEnvDuration("ENRICHMENT_BACKOFF", 5*time.Second),
+		EnrichmentMaxBackoff:   getEnvDuration("ENRICHMENT_MAX_BACKOFF", 10*time.Minute),
+
+		ReenrichInterval: getEnvDuration("REENRICH_INTERVAL", 1500*time.Millisecond),
 	}
 }
 
@@ -38,3 +133,29 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("invalid duration %s=%q, using %s", key, val, fallback)
+		return fallback
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("invalid integer %s=%q, using %d", key, val, fallback)
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,19 @@
+package model
+
+// EnrichmentStatus tracks whether a user's geolocation has been resolved.
+type EnrichmentStatus string
+
+const (
+	EnrichmentPending EnrichmentStatus = "pending"
+	EnrichmentDone    EnrichmentStatus = "done"
+	EnrichmentFailed  EnrichmentStatus = "failed"
+)
+
+// EnrichmentJob is a queued geolocation of a user's IP. Attempts counts the
+// times the job was claimed, including the current one.
+type EnrichmentJob struct {
+	ID       int64
+	UserID   string
+	IP       string
+	Attempts int
+}
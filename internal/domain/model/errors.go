@@ -0,0 +1,58 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrLocationNotFound is returned by GeoIP providers that answered but have
+// no data for the requested IP.
+var ErrLocationNotFound = errors.New("location not found")
+
+// Repository errors, translated by the adapters from their storage errors.
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrDuplicateEmail = errors.New("email already registered")
+	ErrInvalidID      = errors.New("invalid ID")
+)
+
+// ErrForbidden is returned when the caller may not act on a resource.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrInvalidCursor is returned for a pagination cursor that was not issued
+// for the requested sort order or is malformed.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// RateLimitError is returned when a GeoIP provider refuses further lookups
+// until RetryAfter has passed.
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %d seconds", e.Provider, e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds, as used by the
+// Retry-After header.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	secs := int(math.Ceil(e.RetryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// ReservedIPError is returned when an IP that cannot be geolocated (private,
+// loopback, documentation, ...) is rejected by policy.
+type ReservedIPError struct {
+	IP    string
+	Class string
+}
+
+func (e *ReservedIPError) Error() string {
+	return fmt.Sprintf("IP %s is a %s address and cannot be geolocated", e.IP, e.Class)
+}
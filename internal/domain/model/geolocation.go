@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// GeoLocation is the result of resolving an IP address. Providers fill in as
+// much as they know; empty fields mean "unknown".
+type GeoLocation struct {
+	IP          string   `json:"ip"`
+	CountryCode string   `json:"country_code,omitempty"`
+	Country     string   `json:"country,omitempty"`
+	Region      string   `json:"region,omitempty"`
+	City        string   `json:"city,omitempty"`
+	PostalCode  string   `json:"postal_code,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	Timezone    string   `json:"timezone,omitempty"`
+	ASN         uint32   `json:"asn,omitempty"`
+	ISP         string   `json:"isp,omitempty"`
+	Source      string   `json:"source,omitempty"`
+}
+
+// GeoLookupResult is the outcome of resolving one IP of a batch.
+type GeoLookupResult struct {
+	IP       string
+	Location *GeoLocation
+	Err      error
+}
+
+// GeoIPCacheStats is a snapshot of the GeoIP cache counters.
+type GeoIPCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// GeoIPDatabaseInfo describes a file-based GeoIP database currently in use.
+type GeoIPDatabaseInfo struct {
+	Type      string    `json:"type"`
+	Version   string    `json:"version"`
+	BuildDate time.Time `json:"build_date"`
+	Paths     []string  `json:"paths"`
+	LoadedAt  time.Time `json:"loaded_at"`
+	Reloads   int       `json:"reloads"`
+	LastError string    `json:"last_error,omitempty"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// ReenrichSelector picks the users to re-enrich. Users matching any of the
+// set criteria are selected.
+type ReenrichSelector struct {
+	EmptyCountry   bool
+	EnrichedBefore *time.Time
+	IDs            []string
+	Limit          int
+}
+
+// ReenrichChange is one user whose location differs from the stored one,
+// or whose lookup failed.
+type ReenrichChange struct {
+	UserID         string `json:"user_id"`
+	Email          string `json:"email"`
+	IP             string `json:"ip"`
+	OldCountryCode string `json:"old_country_code"`
+	NewCountryCode string `json:"new_country_code,omitempty"`
+	OldCountry     string `json:"old_country"`
+	NewCountry     string `json:"new_country,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ReenrichReport summarizes a re-enrichment run. Nothing is written when
+// DryRun is set. Skipped counts users whose IP changed, or who were
+// deleted, while their lookup ran; their new location is not written.
+type ReenrichReport struct {
+	DryRun    bool             `json:"dry_run"`
+	Selected  int              `json:"selected"`
+	Changed   int              `json:"changed"`
+	Unchanged int              `json:"unchanged"`
+	Failed    int              `json:"failed"`
+	Skipped   int              `json:"skipped"`
+	Changes   []ReenrichChange `json:"changes"`
+}
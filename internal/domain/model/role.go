@@ -0,0 +1,17 @@
+package model
+
+// Role decides what a user may access.
+type Role string
+
+const (
+	// RoleUser may read and change only its own account.
+	RoleUser Role = "user"
+	// RoleAdmin may list, read and change every account and use the admin
+	// endpoints.
+	RoleAdmin Role = "admin"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleAdmin
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Refresh token errors. Both mean the client has to log in again.
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh
+	// token is presented again; its whole family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// RefreshToken is a stored refresh token. Only the hash of the opaque
+// token is kept. Every rotation issues a new token in the same family, so
+// replaying an old one can revoke the whole chain.
+type RefreshToken struct {
+	ID        int64
+	UserID    string
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	// UsedAt is set once the token has been rotated.
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// TokenPair is returned by /login and /token/refresh.
+type TokenPair struct {
+	Token        string `json:"token"         example:"eyJhbGciOiJIUzI1NiIs..."`
+	TokenType    string `json:"token_type"    example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in"    example:"900"`
+	RefreshToken string `json:"refresh_token" example:"b3BhcXVlLXJlZnJlc2gtdG9rZW4..."`
+}
@@ -1,10 +1,50 @@
 package model
 
+import "time"
+
 type User struct {
-	ID           string `json:"id,omitempty"`
-	Name         string `json:"name"`
-	Email        string `json:"email"`
-	IP           string `json:"ip"`
-	Country      string `json:"country,omitempty"`
-	PasswordHash string `json:"-"`
+	ID           string   `json:"id,omitempty"`
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	IP           string   `json:"ip"`
+	Country      string   `json:"country,omitempty"`
+	CountryCode  string   `json:"country_code,omitempty"`
+	Region       string   `json:"region,omitempty"`
+	City         string   `json:"city,omitempty"`
+	PostalCode   string   `json:"postal_code,omitempty"`
+	Latitude     *float64 `json:"latitude,omitempty"`
+	Longitude    *float64 `json:"longitude,omitempty"`
+	Timezone     string   `json:"timezone,omitempty"`
+	ASN          uint32   `json:"asn,omitempty"`
+	ISP          string   `json:"isp,omitempty"`
+	GeoSource    string   `json:"geo_source,omitempty"`
+	PasswordHash string   `json:"-"`
+	Role         Role     `json:"role,omitempty"`
+
+	EnrichmentStatus EnrichmentStatus `json:"enrichment_status,omitempty"`
+	EnrichedAt       *time.Time       `json:"enriched_at,omitempty"`
+
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	LastLoginIP string     `json:"last_login_ip,omitempty"`
+}
+
+// ApplyGeoLocation copies the resolved location onto the user.
+func (u *User) ApplyGeoLocation(loc *GeoLocation) {
+	u.Country = loc.Country
+	u.CountryCode = loc.CountryCode
+	u.Region = loc.Region
+	u.City = loc.City
+	u.PostalCode = loc.PostalCode
+	u.Latitude = loc.Latitude
+	u.Longitude = loc.Longitude
+	u.Timezone = loc.Timezone
+	u.ASN = loc.ASN
+	u.ISP = loc.ISP
+	u.GeoSource = loc.Source
+}
+
+// IsAdmin reports whether the user has the admin role.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
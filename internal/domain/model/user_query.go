@@ -0,0 +1,39 @@
+package model
+
+import (
+	"net/netip"
+	"time"
+)
+
+// UserSort is a field GET /users can be sorted by.
+type UserSort string
+
+const (
+	SortByCreatedAt UserSort = "created_at"
+	SortByName      UserSort = "name"
+	SortByEmail     UserSort = "email"
+	SortByCountry   UserSort = "country"
+)
+
+// UserQuery selects one page of users. Zero-valued filters are ignored.
+// Cursor is the NextCursor of the previous page and must be used with the
+// same sort order.
+type UserQuery struct {
+	Limit  int
+	Cursor string
+	Sort   UserSort
+	Desc   bool
+
+	Country          string
+	Email            string
+	Name             string
+	CIDR             *netip.Prefix
+	RegisteredAfter  *time.Time
+	RegisteredBefore *time.Time
+}
+
+// UserPage is one page of a UserQuery. NextCursor is empty on the last page.
+type UserPage struct {
+	Users      []*User `json:"users"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,25 @@
+package port
+
+import (
+	"context"
+	"time"
+
+	"ip_detector/internal/domain/model"
+)
+
+// EnrichmentQueue stores users whose geolocation is resolved in the
+// background. Jobs are added by UserRepository, in the same transaction
+// that stores a pending user.
+type EnrichmentQueue interface {
+	// Claim locks up to limit due jobs for lease so no other worker picks
+	// them up, and increments their attempt count.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.EnrichmentJob, error)
+	// Complete stores loc on the user, marks it done and removes the job.
+	// A user whose IP no longer is the job's IP is left unchanged.
+	Complete(ctx context.Context, job *model.EnrichmentJob, loc *model.GeoLocation) error
+	// Retry releases the job to be claimed again at runAt.
+	Retry(ctx context.Context, job *model.EnrichmentJob, runAt time.Time, reason string) error
+	// Fail marks the user as failed unless its IP changed, and removes the
+	// job.
+	Fail(ctx context.Context, job *model.EnrichmentJob, reason string) error
+}
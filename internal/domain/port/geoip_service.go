@@ -1,5 +1,33 @@
 package port
 
+import (
+	"context"
+
+	"ip_detector/internal/domain/model"
+)
+
 type GeoIPService interface {
-	GetCountryByIP(ip string) (string, error)
+	Lookup(ctx context.Context, ip string) (*model.GeoLocation, error)
+}
+
+// BatchGeoIPService is implemented by providers that can resolve many IPs in
+// a single round trip. Results are returned in input order; per-IP failures
+// are reported in GeoLookupResult.Err and the returned error is reserved for
+// failures of the whole batch, such as a cancelled context.
+type BatchGeoIPService interface {
+	LookupBatch(ctx context.Context, ips []string) ([]model.GeoLookupResult, error)
+	// NativeBatch reports whether LookupBatch resolves many IPs per round
+	// trip. Decorators that fall back to one lookup per IP report false.
+	NativeBatch() bool
+}
+
+// GeoIPCacheInspector is implemented by caching GeoIP decorators.
+type GeoIPCacheInspector interface {
+	Stats() model.GeoIPCacheStats
+}
+
+// GeoIPDatabaseInspector is implemented by providers backed by local
+// database files, and by decorators wrapping them.
+type GeoIPDatabaseInspector interface {
+	DatabaseInfo() []model.GeoIPDatabaseInfo
 }
@@ -0,0 +1,25 @@
+package port
+
+import (
+	"context"
+
+	"ip_detector/internal/domain/model"
+)
+
+// RefreshTokenStore keeps hashed refresh tokens.
+type RefreshTokenStore interface {
+	// Create stores token and sets its ID, and its FamilyID when empty to
+	// start a new family.
+	Create(ctx context.Context, token *model.RefreshToken) error
+	// GetByHash returns model.ErrNotFound for an unknown hash.
+	GetByHash(ctx context.Context, hash string) (*model.RefreshToken, error)
+	// Rotate marks old as used and stores next in one step. It returns
+	// model.ErrRefreshTokenReused when old was used or revoked meanwhile.
+	Rotate(ctx context.Context, old, next *model.RefreshToken) error
+	// RevokeFamily revokes every token of the family.
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeUser revokes every refresh token of the user.
+	RevokeUser(ctx context.Context, userID string) error
+	// DeleteExpired removes tokens past their expiry and returns how many.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
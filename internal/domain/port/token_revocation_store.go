@@ -0,0 +1,25 @@
+package port
+
+import (
+	"context"
+	"time"
+)
+
+// TokenRevocationStore keeps revoked access tokens and the per-user
+// watermarks before which all of a user's access tokens are invalid.
+type TokenRevocationStore interface {
+	// Revoke stores the token ID jti until expiresAt, when the token
+	// expires anyway.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// ListRevoked returns the expiry of every revoked token that has not
+	// expired yet, by token ID.
+	ListRevoked(ctx context.Context) (map[string]time.Time, error)
+	// DeleteExpired removes revocations of expired tokens.
+	DeleteExpired(ctx context.Context) (int64, error)
+	// SetTokensValidAfter invalidates the user's tokens issued before t,
+	// also for deleted users. Unknown users yield model.ErrNotFound.
+	SetTokensValidAfter(ctx context.Context, userID string, t time.Time) error
+	// ListTokensValidAfter returns the watermarks later than since, by user
+	// ID.
+	ListTokensValidAfter(ctx context.Context, since time.Time) (map[string]time.Time, error)
+}
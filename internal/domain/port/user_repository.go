@@ -3,11 +3,37 @@ package port
 import (
 	"context"
 	"ip_detector/internal/domain/model"
+	"time"
 )
 
+// UserRepository stores users. Implementations report missing users as
+// model.ErrNotFound, a taken email as model.ErrDuplicateEmail and a
+// malformed ID as model.ErrInvalidID.
 type UserRepository interface {
+	// Save stores a new user. A pending user is queued for enrichment in
+	// the same transaction.
 	Save(ctx context.Context, user *model.User) error
-	GetAll(ctx context.Context) ([]*model.User, error)
+	// Query returns one page of users using keyset pagination. An unusable
+	// cursor yields model.ErrInvalidCursor.
+	Query(ctx context.Context, q model.UserQuery) (*model.UserPage, error)
+	// Update stores the profile and location fields of an existing user. Like
+	// Save, it queues a pending user unless a job for its IP exists.
+	Update(ctx context.Context, user *model.User) error
+	// SetRole changes the role of an existing user and, in the same step,
+	// ends its sessions like SoftDelete does.
+	SetRole(ctx context.Context, id string, role model.Role, at time.Time) error
+	// RecordLogin stores the time and client IP of a successful login; ip
+	// may be empty when it is unknown.
+	RecordLogin(ctx context.Context, id, ip string, at time.Time) error
+	// SoftDelete hides the user from all reads and frees its email. In the
+	// same step it ends the user's sessions: access tokens issued before at
+	// become invalid and all refresh tokens are revoked.
+	SoftDelete(ctx context.Context, id string, at time.Time) error
 	GetByID(ctx context.Context, id string) (*model.User, error)
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	FindForReenrichment(ctx context.Context, sel model.ReenrichSelector) ([]*model.User, error)
+	// UpdateGeoLocation stores loc, resolved for ip, on the user and marks it
+	// as enriched now. It returns model.ErrNotFound when the user is gone or
+	// no longer has ip.
+	UpdateGeoLocation(ctx context.Context, id, ip string, loc *model.GeoLocation) error
 }
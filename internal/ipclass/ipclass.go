@@ -0,0 +1,85 @@
+package ipclass
+
+import "net/netip"
+
+// Class describes what kind of address an IP is, as far as geolocation is
+// concerned. Only Public addresses can be meaningfully geolocated.
+type Class string
+
+const (
+	Public        Class = "public"
+	Unspecified   Class = "unspecified"
+	Loopback      Class = "loopback"
+	Private       Class = "private"
+	CGNAT         Class = "cgnat"
+	LinkLocal     Class = "link-local"
+	Documentation Class = "documentation"
+	Benchmarking  Class = "benchmarking"
+	Multicast     Class = "multicast"
+	Broadcast     Class = "broadcast"
+	UniqueLocal   Class = "unique-local"
+	Reserved      Class = "reserved"
+)
+
+type rangeClass struct {
+	prefix netip.Prefix
+	class  Class
+}
+
+// ranges lists special-purpose blocks from the IANA IPv4 and IPv6 registries
+// that are not covered by the netip.Addr helpers used in Classify.
+var ranges = []rangeClass{
+	{netip.MustParsePrefix("0.0.0.0/8"), Reserved},
+	{netip.MustParsePrefix("100.64.0.0/10"), CGNAT},
+	{netip.MustParsePrefix("192.0.0.0/24"), Reserved},
+	{netip.MustParsePrefix("192.0.2.0/24"), Documentation},
+	{netip.MustParsePrefix("198.18.0.0/15"), Benchmarking},
+	{netip.MustParsePrefix("198.51.100.0/24"), Documentation},
+	{netip.MustParsePrefix("203.0.113.0/24"), Documentation},
+	{netip.MustParsePrefix("240.0.0.0/4"), Reserved},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), Reserved},
+	{netip.MustParsePrefix("100::/64"), Reserved},
+	{netip.MustParsePrefix("2001::/23"), Reserved},
+	{netip.MustParsePrefix("2001:db8::/32"), Documentation},
+	{netip.MustParsePrefix("3fff::/20"), Documentation},
+	{netip.MustParsePrefix("fc00::/7"), UniqueLocal},
+}
+
+var broadcast = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// Classify returns the class of addr. IPv4-mapped IPv6 addresses are
+// classified as the IPv4 address they embed.
+func Classify(addr netip.Addr) Class {
+	addr = addr.Unmap()
+
+	switch {
+	case addr.IsUnspecified():
+		return Unspecified
+	case addr.IsLoopback():
+		return Loopback
+	case addr == broadcast:
+		return Broadcast
+	case addr.IsMulticast():
+		return Multicast
+	case addr.IsLinkLocalUnicast():
+		return LinkLocal
+	case addr.Is4() && addr.IsPrivate():
+		return Private
+	}
+
+	for _, r := range ranges {
+		if r.prefix.Contains(addr) {
+			return r.class
+		}
+	}
+	return Public
+}
+
+// ClassifyString parses ip and classifies it.
+func ClassifyString(ip string) (Class, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	return Classify(addr), nil
+}
@@ -0,0 +1,47 @@
+package ipclass_test
+
+import (
+	"testing"
+
+	"ip_detector/internal/ipclass"
+)
+
+func TestClassify(t *testing.T) {
+	cases := map[string]ipclass.Class{
+		"8.8.8.8":             ipclass.Public,
+		"2001:4860::8888":     ipclass.Public,
+		"0.0.0.0":             ipclass.Unspecified,
+		"::":                  ipclass.Unspecified,
+		"127.0.0.1":           ipclass.Loopback,
+		"::1":                 ipclass.Loopback,
+		"10.0.0.5":            ipclass.Private,
+		"172.16.3.4":          ipclass.Private,
+		"192.168.1.1":         ipclass.Private,
+		"::ffff:192.168.1.1":  ipclass.Private,
+		"100.64.0.1":          ipclass.CGNAT,
+		"169.254.1.1":         ipclass.LinkLocal,
+		"fe80::1":             ipclass.LinkLocal,
+		"192.0.2.1":           ipclass.Documentation,
+		"198.51.100.7":        ipclass.Documentation,
+		"203.0.113.9":         ipclass.Documentation,
+		"2001:db8::1":         ipclass.Documentation,
+		"198.18.0.1":          ipclass.Benchmarking,
+		"224.0.0.1":           ipclass.Multicast,
+		"ff02::1":             ipclass.Multicast,
+		"255.255.255.255":     ipclass.Broadcast,
+		"240.0.0.1":           ipclass.Reserved,
+		"fd12:3456:789a:1::1": ipclass.UniqueLocal,
+		"64:ff9b:1::808:808":  ipclass.Reserved,
+		"2001:0:4136:e378::1": ipclass.Reserved,
+	}
+
+	for ip, want := range cases {
+		got, err := ipclass.ClassifyString(ip)
+		if err != nil {
+			t.Fatalf("%s: %v", ip, err)
+		}
+		if got != want {
+			t.Errorf("%s: want %s, got %s", ip, want, got)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS country_code,
+    DROP COLUMN IF EXISTS region,
+    DROP COLUMN IF EXISTS city,
+    DROP COLUMN IF EXISTS postal_code,
+    DROP COLUMN IF EXISTS latitude,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS asn,
+    DROP COLUMN IF EXISTS isp;
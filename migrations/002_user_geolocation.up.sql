@@ -0,0 +1,10 @@
+ALTER TABLE users
+    ADD COLUMN country_code TEXT NOT NULL DEFAULT '',
+    ADD COLUMN region TEXT NOT NULL DEFAULT '',
+    ADD COLUMN city TEXT NOT NULL DEFAULT '',
+    ADD COLUMN postal_code TEXT NOT NULL DEFAULT '',
+    ADD COLUMN latitude DOUBLE PRECISION,
+    ADD COLUMN longitude DOUBLE PRECISION,
+    ADD COLUMN timezone TEXT NOT NULL DEFAULT '',
+    ADD COLUMN asn BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN isp TEXT NOT NULL DEFAULT '';
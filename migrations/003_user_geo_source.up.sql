@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN geo_source TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS enrichment_jobs;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS enrichment_status,
+    DROP COLUMN IF EXISTS enriched_at;
@@ -0,0 +1,17 @@
+ALTER TABLE users
+    ADD COLUMN enrichment_status TEXT NOT NULL DEFAULT 'done',
+    ADD COLUMN enriched_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS enrichment_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS enrichment_jobs_run_at_idx ON enrichment_jobs (run_at);
+CREATE INDEX IF NOT EXISTS enrichment_jobs_user_id_idx ON enrichment_jobs (user_id, ip);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_ip_gist_idx;
+
+ALTER TABLE users ALTER COLUMN ip TYPE TEXT USING host(ip);
@@ -0,0 +1,9 @@
+ALTER TABLE users
+    ALTER COLUMN ip TYPE INET USING (
+        CASE WHEN ip::inet <<= '::ffff:0:0/96'
+            THEN '0.0.0.0'::inet + (ip::inet - '::ffff:0:0'::inet)
+            ELSE ip::inet
+        END
+    );
+
+CREATE INDEX IF NOT EXISTS users_ip_gist_idx ON users USING gist (ip inet_ops);
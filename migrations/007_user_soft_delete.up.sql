@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Deleted users keep their row but must not block the email.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_active_idx ON users (email) WHERE deleted_at IS NULL;